@@ -0,0 +1,145 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// ackTracker 记录单个文件中已经投递但是还未被确认的数据
+// 只有从头开始连续被确认的数据，其位点才会被提交，从而保证 at-least-once 语义
+type ackTracker struct {
+	lock    sync.Mutex
+	pending []*pendingAck
+	// notify 每次有数据被提交时通知等待者
+	notify chan struct{}
+	// onCommit 提交位点的回调，offset 为最新被连续确认的位点，lines 为本次提交的行数
+	onCommit func(offset int64, lines int)
+}
+
+// pendingAck 一行等待被确认的数据
+type pendingAck struct {
+	tracker *ackTracker
+	// offset 该行数据结束的位点
+	offset int64
+	// remain 还有多少个 Sink 没有确认
+	remain int32
+	acked  bool
+}
+
+func newAckTracker(onCommit func(offset int64, lines int)) *ackTracker {
+	return &ackTracker{
+		notify:   make(chan struct{}, 1),
+		onCommit: onCommit,
+	}
+}
+
+// add 按照读取顺序记录一行等待被确认的数据
+//
+//	@receiver t
+//	@param offset
+//	@return *pendingAck
+func (t *ackTracker) add(offset int64) *pendingAck {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	p := &pendingAck{
+		tracker: t,
+		offset:  offset,
+	}
+	t.pending = append(t.pending, p)
+	return p
+}
+
+// wait 等待所有已经投递的数据都被确认
+//
+//	@receiver t
+//	@param ctx
+//	@return error
+func (t *ackTracker) wait(ctx context.Context) error {
+	for {
+		t.lock.Lock()
+		size := len(t.pending)
+		t.lock.Unlock()
+
+		if size == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.notify:
+		}
+	}
+}
+
+// ack 确认一行数据，并提交连续被确认的位点
+func (t *ackTracker) ack(p *pendingAck) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	p.acked = true
+
+	var (
+		offset int64
+		lines  int
+	)
+	for len(t.pending) > 0 && t.pending[0].acked {
+		offset = t.pending[0].offset
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+		lines++
+	}
+	if lines == 0 {
+		return
+	}
+
+	t.onCommit(offset, lines)
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+// expect 设置这行数据需要被多少个 Sink 确认，n 为 0 时直接确认
+//
+//	@receiver p
+//	@param n
+func (p *pendingAck) expect(n int) {
+	if n <= 0 {
+		p.tracker.ack(p)
+		return
+	}
+	atomic.StoreInt32(&p.remain, int32(n))
+}
+
+// done 一个 Sink 完成了确认
+//
+//	@receiver p
+func (p *pendingAck) done() {
+	if atomic.AddInt32(&p.remain, -1) == 0 {
+		p.tracker.ack(p)
+	}
+}
@@ -0,0 +1,160 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAckTracker(t *testing.T) {
+	var (
+		committed int64
+		lines     int
+	)
+	tracker := newAckTracker(func(offset int64, n int) {
+		committed = offset
+		lines += n
+	})
+
+	first := tracker.add(10)
+	second := tracker.add(20)
+	third := tracker.add(30)
+	first.expect(1)
+	second.expect(2)
+	third.expect(1)
+
+	// 后面的数据先被确认，不能提交位点
+	third.done()
+	if committed != 0 {
+		t.Fatalf("offset should not be committed, actual=%d", committed)
+	}
+
+	first.done()
+	if committed != 10 || lines != 1 {
+		t.Fatalf("expect commit offset 10, actual=%d", committed)
+	}
+
+	// 需要所有 Sink 都确认
+	second.done()
+	if committed != 10 {
+		t.Fatalf("offset should not be committed before all sinks ack, actual=%d", committed)
+	}
+	second.done()
+	if committed != 30 || lines != 3 {
+		t.Fatalf("expect commit offset 30, actual=%d", committed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tracker.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHarvester_AckSink(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "app_line=1", "app_line=2")
+
+	sink := &mockAckSink{failTimes: 1}
+	beater := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, ".*\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+	})
+	beater.RegisterAckSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+
+	sink.waitFor(t, 2)
+	registry := beater.(*harvester).registry
+	for _, state := range registry.Snapshot() {
+		if state.Offset != 0 {
+			t.Fatalf("offset should not be committed before ack, actual=%d", state.Offset)
+		}
+	}
+
+	sink.ackAll()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, state := range registry.Snapshot() {
+			if state.Source == name && state.Offset == int64(len("app_line=1\napp_line=2\n")) {
+				return
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("offset should be committed after ack : %+v", registry.Snapshot())
+}
+
+type mockAckSink struct {
+	lock      sync.Mutex
+	failTimes int
+	msgs      []string
+	acks      []AckFunc
+}
+
+// OnEvent
+func (s *mockAckSink) OnEvent(event *Event, ack AckFunc) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.failTimes > 0 {
+		s.failTimes--
+		return errors.New("mock sink fail")
+	}
+	s.msgs = append(s.msgs, event.String())
+	s.acks = append(s.acks, ack)
+	return nil
+}
+
+func (s *mockAckSink) ackAll() {
+	s.lock.Lock()
+	acks := s.acks
+	s.acks = nil
+	s.lock.Unlock()
+
+	for i := range acks {
+		acks[i]()
+	}
+}
+
+func (s *mockAckSink) waitFor(t *testing.T, expect int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		s.lock.Lock()
+		size := len(s.msgs)
+		s.lock.Unlock()
+		if size >= expect {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("wait for %d messages timeout", expect)
+}
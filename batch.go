@@ -0,0 +1,80 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"time"
+)
+
+const (
+	// defaultBatchMaxCount 一批数据默认的最大行数
+	defaultBatchMaxCount = 1024
+	// defaultBatchMaxBytes 一批数据默认的最大字节数
+	defaultBatchMaxBytes = 1 << 20
+	// defaultBatchMaxLinger 数据默认最多等待多久就会被投递
+	defaultBatchMaxLinger = 100 * time.Millisecond
+)
+
+// BatchConfig 批量投递给 Sink 的配置，任意一个条件满足都会触发投递
+type BatchConfig struct {
+	// MaxCount 一批数据的最大行数，<= 0 时使用默认值 1024
+	MaxCount int
+	// MaxBytes 一批数据的最大字节数，<= 0 时使用默认值 1MiB
+	MaxBytes int
+	// MaxLinger 数据在批次中最多等待多久就会被投递，<= 0 时使用默认值 100ms
+	MaxLinger time.Duration
+}
+
+func (cfg BatchConfig) withDefaults() BatchConfig {
+	if cfg.MaxCount <= 0 {
+		cfg.MaxCount = defaultBatchMaxCount
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultBatchMaxBytes
+	}
+	if cfg.MaxLinger <= 0 {
+		cfg.MaxLinger = defaultBatchMaxLinger
+	}
+	return cfg
+}
+
+// batch 正在攒批中的数据
+type batch struct {
+	msgs  []message
+	bytes int
+}
+
+// add 加入一条数据，返回当前批次是否已经满足投递条件
+func (b *batch) add(msg message, cfg BatchConfig) bool {
+	b.msgs = append(b.msgs, msg)
+	b.bytes += len(msg.event.Content)
+	return len(b.msgs) >= cfg.MaxCount || b.bytes >= cfg.MaxBytes
+}
+
+// take 取出当前批次的全部数据
+func (b *batch) take() []message {
+	msgs := b.msgs
+	b.msgs = nil
+	b.bytes = 0
+	return msgs
+}
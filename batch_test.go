@@ -0,0 +1,122 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHarvester_BatchSink(t *testing.T) {
+	dir := t.TempDir()
+	writeLines(t, filepath.Join(dir, "app.log"), "line=1", "line=2", "line=3", "line=4", "line=5")
+
+	sink := &mockBatchSink{}
+	beater := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, ".*\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+		Batch: BatchConfig{
+			MaxCount:  2,
+			MaxLinger: 200 * time.Millisecond,
+		},
+	})
+	beater.RegisterBatchSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) && len(sink.sizes()) < 3 {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// 前两批由 MaxCount 触发，最后一批由 MaxLinger 触发
+	sizes := sink.sizes()
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("unexpect batch sizes : %v", sizes)
+	}
+}
+
+func TestAdaptSink(t *testing.T) {
+	events := []*Event{{Content: []byte("line=1")}, {Content: []byte("line=2")}}
+	sink := &mockSink{}
+	acked := 0
+	if err := AdaptSink(sink).OnBatch(events, func() {
+		acked++
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if acked != 1 || len(sink.messages()) != 2 {
+		t.Fatalf("unexpect adapt result, acked=%d, msgs=%v", acked, sink.messages())
+	}
+
+	ackSink := &mockAckSink{}
+	acked = 0
+	if err := AdaptAckSink(ackSink).OnBatch(events, func() {
+		acked++
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if acked != 0 {
+		t.Fatal("batch should not be acked before each line is acked")
+	}
+	ackSink.ackAll()
+	ackSink.ackAll()
+	if acked != 1 {
+		t.Fatalf("batch should be acked once, actual=%d", acked)
+	}
+}
+
+type mockBatchSink struct {
+	lock    sync.Mutex
+	batches [][]string
+}
+
+// OnBatch
+func (s *mockBatchSink) OnBatch(events []*Event, ack AckFunc) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	msgs := make([]string, 0, len(events))
+	for i := range events {
+		msgs = append(msgs, events[i].String())
+	}
+	s.batches = append(s.batches, msgs)
+	ack()
+	return nil
+}
+
+func (s *mockBatchSink) sizes() []int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := make([]int, 0, len(s.batches))
+	for i := range s.batches {
+		ret = append(ret, len(s.batches[i]))
+	}
+	return ret
+}
@@ -0,0 +1,186 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCheckpointInterval 没有配置任何落盘策略时，默认的落盘间隔
+	defaultCheckpointInterval = time.Second
+)
+
+// CheckpointConfig 采集位点的落盘策略，多个策略可以同时生效
+type CheckpointConfig struct {
+	// EveryLines 每确认 N 行数据落盘一次，<= 0 表示不按照行数落盘
+	EveryLines int
+	// Interval 每隔一段时间落盘一次，<= 0 表示不按照时间落盘
+	// 如果没有配置任何策略，默认每秒落盘一次
+	Interval time.Duration
+	// OnAck 每次有数据被确认后都立即落盘
+	OnAck bool
+}
+
+// checkpointer 负责将 Registry 安全的持久化到 MetaPath 中
+type checkpointer struct {
+	lock     sync.Mutex
+	path     string
+	cfg      CheckpointConfig
+	registry *Registry
+	onError  func(err error)
+
+	// pending 自上次落盘以来被确认的行数
+	pending int
+	// dirty 自上次落盘以来 Registry 是否发生过变化
+	dirty bool
+}
+
+func newCheckpointer(path string, cfg CheckpointConfig, registry *Registry, onError func(err error)) *checkpointer {
+	if cfg.EveryLines <= 0 && cfg.Interval <= 0 && !cfg.OnAck {
+		cfg.Interval = defaultCheckpointInterval
+	}
+	return &checkpointer{
+		path:     path,
+		cfg:      cfg,
+		registry: registry,
+		onError:  onError,
+	}
+}
+
+// run 按照 Interval 定时落盘，ctx 结束时会进行最后一次落盘
+//
+//	@receiver c
+//	@param ctx
+func (c *checkpointer) run(ctx context.Context) {
+	if c.cfg.Interval <= 0 {
+		<-ctx.Done()
+		c.flushIfDirty()
+		return
+	}
+
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flushIfDirty()
+		case <-ctx.Done():
+			c.flushIfDirty()
+			return
+		}
+	}
+}
+
+// onAck 有数据被确认，根据落盘策略判断是否需要立即落盘
+//
+//	@receiver c
+//	@param lines
+func (c *checkpointer) onAck(lines int) {
+	c.lock.Lock()
+	c.dirty = true
+	c.pending += lines
+	needFlush := c.cfg.OnAck || (c.cfg.EveryLines > 0 && c.pending >= c.cfg.EveryLines)
+	c.lock.Unlock()
+
+	if needFlush {
+		c.flushIfDirty()
+	}
+}
+
+// markDirty Registry 发生了变化，等待下一次落盘
+func (c *checkpointer) markDirty() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.dirty = true
+}
+
+func (c *checkpointer) flushIfDirty() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.dirty {
+		return
+	}
+	if err := c.flush(); err != nil {
+		c.onError(err)
+	}
+}
+
+// flush 将 Registry 写入 MetaPath，调用方需要持有 lock
+func (c *checkpointer) flush() error {
+	data, err := json.Marshal(c.registry)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, data); err != nil {
+		return err
+	}
+	c.dirty = false
+	c.pending = 0
+	return nil
+}
+
+// writeFileAtomic 先写入临时文件并 fsync，再通过 rename 替换目标文件，保证目标文件要么是旧的内容要么是完整的新内容
+//
+//	@param name
+//	@param data
+//	@return error
+func writeFileAtomic(name string, data []byte) error {
+	tmpName := name + ".tmp"
+	f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir fsync 目录，保证 rename 操作本身被持久化
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,80 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "meta")
+	if err := ioutil.WriteFile(name, []byte(`{"version":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(name, []byte(`{"version":1,"files":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"version":1,"files":{}}` {
+		t.Fatalf("unexpect content : %s", string(data))
+	}
+	if _, err := os.Stat(name + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temp file should be renamed : %v", err)
+	}
+}
+
+func TestCheckpointer_EveryLines(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "meta")
+	registry := NewRegistry()
+	c := newCheckpointer(name, CheckpointConfig{EveryLines: 3}, registry, func(err error) {
+		t.Fatal(err)
+	})
+
+	for i := 1; i <= 3; i++ {
+		registry.Update("1-2", func(state *FileState) {
+			state.Offset = int64(i)
+		})
+		c.onAck(1)
+
+		data, _ := ioutil.ReadFile(name)
+		loaded, err := LoadRegistry(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, ok := loaded.Get("1-2")
+		if i < 3 && ok {
+			t.Fatalf("should not flush before %d lines, offset=%d", 3, state.Offset)
+		}
+		if i == 3 && (!ok || state.Offset != 3) {
+			t.Fatalf("should flush after %d lines : %+v", 3, state)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// CleanConfig Registry 中过期记录的清理策略，每次扫描待处理文件列表时执行
+type CleanConfig struct {
+	// Removed 清理在最后记录的路径下已经找不到的文件（被删除或者被重命名为不再匹配的名称）
+	Removed bool
+	// Inactive 清理超过该时长没有被扫描到的文件，<= 0 表示不清理
+	Inactive time.Duration
+}
+
+// Stats harvester 运行过程中的统计信息
+type Stats struct {
+	// CleanedRemoved 按照 CleanConfig.Removed 清理的 Registry 记录数
+	CleanedRemoved int64
+	// CleanedInactive 按照 CleanConfig.Inactive 清理的 Registry 记录数
+	CleanedInactive int64
+	// DroppedLines 不满足 Config.IncludeLines、Config.ExcludeLines 被丢弃的数据条数
+	DroppedLines int64
+}
+
+// Stats 获取运行过程中的统计信息
+//
+//	@receiver beater
+//	@return Stats
+func (beater *harvester) Stats() Stats {
+	return Stats{
+		CleanedRemoved:  atomic.LoadInt64(&beater.stats.CleanedRemoved),
+		CleanedInactive: atomic.LoadInt64(&beater.stats.CleanedInactive),
+		DroppedLines:    atomic.LoadInt64(&beater.stats.DroppedLines),
+	}
+}
+
+// cleanRegistry 按照 CleanConfig 清理 Registry 中的记录，正在采集中的文件不会被清理
+//
+//	@receiver beater
+//	@param seen 本次扫描发现的文件
+func (beater *harvester) cleanRegistry(seen map[string]struct{}) {
+	cfg := beater.cfg.Clean
+	if !cfg.Removed && cfg.Inactive <= 0 {
+		return
+	}
+
+	now := time.Now()
+	cleaned := false
+	for key, state := range beater.registry.Snapshot() {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+
+		var reason *int64
+		switch {
+		case cfg.Inactive > 0 && now.Sub(state.LastSeen) > cfg.Inactive:
+			reason = &beater.stats.CleanedInactive
+		case cfg.Removed && isGone(state):
+			reason = &beater.stats.CleanedRemoved
+		default:
+			continue
+		}
+
+		beater.lock.Lock()
+		_, harvesting := beater.workers[key]
+		if !harvesting {
+			delete(beater.inactive, key)
+			beater.registry.Remove(key)
+		}
+		beater.lock.Unlock()
+		if harvesting {
+			continue
+		}
+
+		atomic.AddInt64(reason, 1)
+		cleaned = true
+		beater.logger.Infof("harvester clean registry entry : %s, source : %s", key, state.Source)
+	}
+	if cleaned {
+		beater.checkpoint.markDirty()
+	}
+}
+
+// isGone 判断文件在最后记录的路径下是否已经找不到了
+func isGone(state FileState) bool {
+	if state.Source == "" {
+		return true
+	}
+	info, err := os.Stat(state.Source)
+	if err != nil {
+		return errors.Is(err, os.ErrNotExist)
+	}
+	return GetOSState(info) != state.State
+}
@@ -0,0 +1,126 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHarvester_CleanRemoved(t *testing.T) {
+	dir := t.TempDir()
+	removed := filepath.Join(dir, "removed.log")
+	writeLines(t, removed, "removed_line=1")
+	writeLines(t, filepath.Join(dir, "app.log"), "app_line=1")
+	stat, err := os.Stat(removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := GetOSState(stat).String()
+
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Path:     filepath.Join(dir, ".*\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+		Clean: CleanConfig{
+			Removed: true,
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 2)
+
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return beater.Stats().CleanedRemoved == 1
+	})
+	if _, ok := beater.registry.Get(key); ok {
+		t.Fatal("registry entry of removed file should be cleaned")
+	}
+	if size := len(beater.registry.Snapshot()); size != 1 {
+		t.Fatalf("registry entry of existing file should be kept, actual=%d", size)
+	}
+}
+
+func TestHarvester_CleanInactive(t *testing.T) {
+	dir := t.TempDir()
+	writeLines(t, filepath.Join(dir, "app.log"), "app_line=1")
+
+	// 上次运行时记录的文件已经很久没有被扫描到了
+	registry := NewRegistry()
+	registry.Update("1-1", func(state *FileState) {
+		state.Source = filepath.Join(dir, "history.log")
+		state.State = StateOS{Inode: 1, Device: 1}
+		state.Offset = 100
+		state.LastSeen = time.Now().Add(-2 * time.Hour)
+	})
+	data, err := json.Marshal(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "meta"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Path:     filepath.Join(dir, ".*\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+		Clean: CleanConfig{
+			Inactive: time.Hour,
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 1)
+
+	waitFor(t, func() bool {
+		return beater.Stats().CleanedInactive == 1
+	})
+	if _, ok := beater.registry.Get("1-1"); ok {
+		t.Fatal("inactive registry entry should be cleaned")
+	}
+	if stats := beater.Stats(); stats.CleanedRemoved != 0 {
+		t.Fatalf("unexpect stats : %+v", stats)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("wait for condition timeout")
+}
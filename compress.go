@@ -0,0 +1,102 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrorFinished 文件已经全部读取完成，并且之后不会再发生变化（例如压缩文件）
+	ErrorFinished error = errors.New("log already finished")
+
+	// ErrorUnsupportedCompression 没有注册该后缀对应的解压方式
+	ErrorUnsupportedCompression error = errors.New("unsupported compression")
+
+	decompressorLock sync.RWMutex
+	// decompressors 压缩文件后缀对应的解压方式
+	decompressors = map[string]Decompressor{
+		".gz": func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}
+)
+
+// Decompressor 根据压缩文件的内容构造解压后的数据流
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// RegisterDecompressor 注册压缩文件后缀对应的解压方式，默认支持 .gz
+// 例如可以使用 github.com/klauspost/compress/zstd 注册 .zst
+//
+//	@param suffix 包含 . 的文件后缀
+//	@param fn
+func RegisterDecompressor(suffix string, fn Decompressor) {
+	decompressorLock.Lock()
+	defer decompressorLock.Unlock()
+
+	decompressors[strings.ToLower(suffix)] = fn
+}
+
+// findDecompressor 根据文件后缀查找解压方式
+func findDecompressor(name string) (Decompressor, bool) {
+	decompressorLock.RLock()
+	defer decompressorLock.RUnlock()
+
+	fn, ok := decompressors[strings.ToLower(filepath.Ext(name))]
+	return fn, ok
+}
+
+// isCompressed 开启 Config.Decompress 时，判断文件是否需要解压读取
+//
+//	@receiver beater
+//	@param name
+//	@return bool
+func (beater *harvester) isCompressed(name string) bool {
+	if !beater.cfg.Decompress {
+		return false
+	}
+	_, ok := findDecompressor(name)
+	return ok
+}
+
+// rotationSource 查找轮转后被压缩的文件对应的原文件的处理信息，压缩文件按照解压后的内容计算的指纹与原文件相同
+//
+//	@receiver beater
+//	@param item
+//	@return string 原文件在 Registry 中的 key
+//	@return FileState
+//	@return bool
+func (beater *harvester) rotationSource(item fileInfo) (string, FileState, bool) {
+	source := strings.TrimSuffix(item.path, filepath.Ext(item.path))
+	return beater.registry.findFingerprint(item.fingerprint, source)
+}
+
+// isIncomplete 压缩文件还没有写完（例如 logrotate 正在压缩）
+func isIncomplete(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
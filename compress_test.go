@@ -0,0 +1,168 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHarvester_Decompress(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log.1.gz")
+	writeGzip(t, name, "gz_line=1\ngz_line=2\n")
+	writeLines(t, filepath.Join(dir, "app.log"), "app_line=1")
+
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Path:       filepath.Join(dir, "app\\.log.*"),
+		MetaPath:   filepath.Join(dir, "meta"),
+		Decompress: true,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 3)
+	time.Sleep(200 * time.Millisecond)
+
+	actual := sink.messages()
+	sort.Strings(actual)
+	if len(actual) != 3 || actual[0] != "app_line=1" || actual[1] != "gz_line=1" || actual[2] != "gz_line=2" {
+		t.Fatalf("unexpect messages : %v", actual)
+	}
+
+	// 压缩文件只会被读取一次，之后标记为采集完成，解压后的内容不足 Fingerprint.Length 时按照完整的内容识别
+	fingerprint, err := fileFingerprint(name, beater.cfg.Fingerprint, decompressors[".gz"], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		state, _ := beater.registry.Get(fingerprint)
+		return state.Finished && state.Offset == 20
+	})
+}
+
+func TestHarvester_DecompressRotate(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "line_1", "line_2")
+
+	// 默认使用 inode 识别未压缩的文件，长度不足 Fingerprint.Length 的文件也会被采集
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Path:       filepath.Join(dir, "app\\.log.*"),
+		MetaPath:   filepath.Join(dir, "meta"),
+		Decompress: true,
+	})
+	if beater.cfg.FileIdentity != IdentityInode {
+		t.Fatalf("expect inode identity, actual=%s", beater.cfg.FileIdentity)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 2)
+	time.Sleep(200 * time.Millisecond)
+
+	// logrotate：重命名之后压缩，并删除未压缩的文件
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, name, "line_3")
+	time.Sleep(200 * time.Millisecond)
+	writeGzip(t, name+".1.gz", "line_1\nline_2\n")
+	if err := os.Remove(name + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	sink.waitFor(t, 3)
+
+	// 压缩文件从原文件已经采集到的位点继续采集，读完后标记为采集完成
+	fingerprint, err := fileFingerprint(name+".1.gz", beater.cfg.Fingerprint, decompressors[".gz"], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		state, _ := beater.registry.Get(fingerprint)
+		return state.Finished && state.Offset == 14
+	})
+	if msgs := sink.messages(); len(msgs) != 3 || msgs[2] != "line_3" {
+		t.Fatalf("rotated and compressed file should not be harvested again, actual=%v", msgs)
+	}
+}
+
+func TestHarvester_DecompressFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "app_line=1", "app_line=2")
+
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Path:         filepath.Join(dir, "app\\.log(\\.\\d+\\.gz)?$"),
+		MetaPath:     filepath.Join(dir, "meta"),
+		Decompress:   true,
+		FileIdentity: IdentityFingerprint,
+		Fingerprint: FingerprintConfig{
+			Length: 8,
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 2)
+
+	// 轮转后被压缩的文件与原文件的指纹相同，不会被重复采集
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeGzip(t, name+".1.gz", "app_line=1\napp_line=2\n")
+	if err := os.Remove(name + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, name, "new_line=1")
+	sink.waitFor(t, 3)
+	time.Sleep(300 * time.Millisecond)
+
+	if msgs := sink.messages(); len(msgs) != 3 || msgs[2] != "new_line=1" {
+		t.Fatalf("compressed file should not be harvested again, actual=%v", msgs)
+	}
+}
+
+func writeGzip(t *testing.T, name string, content string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := gzip.NewWriter(f)
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,315 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// ContainerAuto 按照每一行的内容自动识别 docker 或者 cri 格式
+	ContainerAuto = "auto"
+	// ContainerDocker docker json-file 格式，{"log":"...","stream":"stdout","time":"..."}
+	ContainerDocker = "docker"
+	// ContainerCRI containerd、CRI-O 等 CRI 运行时的格式，<time> <stream> <P|F> <log>
+	ContainerCRI = "cri"
+
+	// StreamAll 采集所有输出流
+	StreamAll = "all"
+	// StreamStdout 只采集标准输出
+	StreamStdout = "stdout"
+	// StreamStderr 只采集标准错误输出
+	StreamStderr = "stderr"
+
+	// FieldStream 容器日志的输出流在 Event.Fields 中的 key
+	FieldStream = "stream"
+	// FieldTime 容器运行时记录的日志时间在 Event.Fields 中的 key，值为 time.Time
+	FieldTime = "time"
+
+	// defaultContainerMaxBytes 分段合并后一条事件默认最多的字节数
+	defaultContainerMaxBytes = 10 << 20
+)
+
+var (
+	// ErrorContainerFormat 不支持的 ContainerConfig.Format 配置
+	ErrorContainerFormat error = errors.New("container format must be auto, docker or cri")
+	// ErrorContainerStream 不支持的 ContainerConfig.Stream 配置
+	ErrorContainerStream error = errors.New("container stream must be all, stdout or stderr")
+	// ErrorContainerLine 一行数据不符合容器日志的格式
+	ErrorContainerLine error = errors.New("invalid container log line")
+)
+
+// ContainerConfig 解析容器日志格式的配置
+type ContainerConfig struct {
+	// Format 日志格式，auto、docker 或者 cri，为空时默认为 auto
+	Format string
+	// Stream 采集哪个输出流的数据，all、stdout 或者 stderr，为空时默认为 all
+	Stream string
+	// MaxBytes 分段数据合并后一条事件内容最多的字节数，超过的部分会被丢弃，<= 0 时使用默认值 10MiB
+	MaxBytes int
+}
+
+func (cfg ContainerConfig) withDefaults() ContainerConfig {
+	if cfg.Format == "" {
+		cfg.Format = ContainerAuto
+	}
+	if cfg.Stream == "" {
+		cfg.Stream = StreamAll
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultContainerMaxBytes
+	}
+	return cfg
+}
+
+// validate 检查配置是否合法
+func (cfg ContainerConfig) validate() error {
+	cfg = cfg.withDefaults()
+	if cfg.Format != ContainerAuto && cfg.Format != ContainerDocker && cfg.Format != ContainerCRI {
+		return ErrorContainerFormat
+	}
+	if cfg.Stream != StreamAll && cfg.Stream != StreamStdout && cfg.Stream != StreamStderr {
+		return ErrorContainerStream
+	}
+	return nil
+}
+
+// ContainerReader 解析 docker json-file 以及 CRI 格式的容器日志，Event.Content 为容器输出的原始日志
+// 输出流以及时间分别记录在 Event.Fields 的 FieldStream、FieldTime 中，被容器运行时拆分的分段数据会合并为一条 Event
+// 无法解析的行不会被丢弃，保留原始内容并在 Event 上标记 FlagContainerError
+type ContainerReader struct {
+	reader Reader
+	cfg    ContainerConfig
+
+	// partials 各个输出流中尚未结束的分段数据，不同输出流的分段数据可能交替出现
+	partials map[string]*Event
+	// pendingErr 投递完尚未结束的分段数据之后需要返回的错误
+	pendingErr error
+}
+
+// NewContainerReader 构造一个解析容器日志的 Reader
+//
+//	@param reader
+//	@param cfg
+//	@return Reader
+//	@return error
+func NewContainerReader(reader Reader, cfg ContainerConfig) (Reader, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &ContainerReader{
+		reader:   reader,
+		cfg:      cfg.withDefaults(),
+		partials: make(map[string]*Event),
+	}, nil
+}
+
+// CurFile
+func (cr *ContainerReader) CurFile() *os.File {
+	return cr.reader.CurFile()
+}
+
+// Offset 如果有尚未结束的分段数据，返回其中最小的起始位点
+func (cr *ContainerReader) Offset() int64 {
+	offset := cr.reader.Offset()
+	for _, partial := range cr.partials {
+		if partial.Offset < offset {
+			offset = partial.Offset
+		}
+	}
+	return offset
+}
+
+// Close
+func (cr *ContainerReader) Close() error {
+	return cr.reader.Close()
+}
+
+// Next
+func (cr *ContainerReader) Next() (*Event, error) {
+	if cr.pendingErr != nil {
+		// 文件已经不会再有后续的分段了，先把尚未结束的分段数据逐个投递出去
+		if event := cr.flushOne(); event != nil {
+			return event, nil
+		}
+		err := cr.pendingErr
+		cr.pendingErr = nil
+		return nil, err
+	}
+	for {
+		event, err := cr.reader.Next()
+		if err != nil {
+			if len(cr.partials) == 0 || errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			cr.pendingErr = err
+			return cr.flushOne(), nil
+		}
+
+		stream, partial, err := cr.parse(event)
+		if err != nil {
+			event.AddFlag(FlagContainerError)
+			return cr.limitEndOffset(event), nil
+		}
+		if cr.cfg.Stream != StreamAll && stream != cr.cfg.Stream {
+			continue
+		}
+		if ret := cr.merge(stream, event, partial); ret != nil {
+			return cr.limitEndOffset(ret), nil
+		}
+	}
+}
+
+// parse 解析一行容器日志，返回输出流以及是否为分段数据
+func (cr *ContainerReader) parse(event *Event) (string, bool, error) {
+	format := cr.cfg.Format
+	if format == ContainerAuto {
+		format = ContainerCRI
+		if len(event.Content) > 0 && event.Content[0] == '{' {
+			format = ContainerDocker
+		}
+	}
+	if format == ContainerDocker {
+		return parseDockerLine(event)
+	}
+	return parseCRILine(event)
+}
+
+// parseDockerLine 解析 docker json-file 格式的一行数据，log 不以换行符结尾时为超过 16KiB 被拆分的分段数据
+func parseDockerLine(event *Event) (string, bool, error) {
+	var line struct {
+		Log    string    `json:"log"`
+		Stream string    `json:"stream"`
+		Time   time.Time `json:"time"`
+	}
+	if err := json.Unmarshal(event.Content, &line); err != nil {
+		return "", false, err
+	}
+	if line.Stream == "" {
+		return "", false, ErrorContainerLine
+	}
+	content := []byte(line.Log)
+	partial := !bytes.HasSuffix(content, []byte{'\n'})
+	content = bytes.TrimSuffix(content, []byte{'\n'})
+	content = bytes.TrimSuffix(content, []byte{'\r'})
+
+	event.Content = content
+	event.PutField(FieldStream, line.Stream)
+	if !line.Time.IsZero() {
+		event.PutField(FieldTime, line.Time)
+	}
+	return line.Stream, partial, nil
+}
+
+// parseCRILine 解析 CRI 格式的一行数据，<time> <stream> <P|F> <log>，P 表示分段数据
+func parseCRILine(event *Event) (string, bool, error) {
+	fields := bytes.SplitN(event.Content, []byte{' '}, 4)
+	if len(fields) < 3 {
+		return "", false, ErrorContainerLine
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(fields[0]))
+	if err != nil {
+		return "", false, err
+	}
+	stream := string(fields[1])
+	if stream != StreamStdout && stream != StreamStderr {
+		return "", false, ErrorContainerLine
+	}
+	tag := string(fields[2])
+	if tag != "P" && tag != "F" {
+		return "", false, ErrorContainerLine
+	}
+
+	var content []byte
+	if len(fields) == 4 {
+		content = fields[3]
+	}
+	event.Content = content
+	event.PutField(FieldStream, stream)
+	event.PutField(FieldTime, ts)
+	return stream, tag == "P", nil
+}
+
+// merge 合并输出流中的分段数据，返回已经结束的 Event，尚未结束时返回 nil
+func (cr *ContainerReader) merge(stream string, event *Event, partial bool) *Event {
+	buffer, ok := cr.partials[stream]
+	if !ok {
+		if partial {
+			cr.partials[stream] = event
+			cr.truncate(event)
+			return nil
+		}
+		return event
+	}
+
+	buffer.EndOffset = event.EndOffset
+	if !buffer.HasFlag(FlagTruncated) {
+		buffer.Content = append(buffer.Content, event.Content...)
+		cr.truncate(buffer)
+	}
+	if partial {
+		return nil
+	}
+	delete(cr.partials, stream)
+	return buffer
+}
+
+// truncate 丢弃超过 MaxBytes 的内容
+func (cr *ContainerReader) truncate(event *Event) {
+	if len(event.Content) > cr.cfg.MaxBytes {
+		event.Content = event.Content[:cr.cfg.MaxBytes]
+		event.AddFlag(FlagTruncated)
+	}
+}
+
+// limitEndOffset 其他输出流还有尚未结束的分段数据时，Event 的结束位点不能超过这些分段数据的起始位点
+// 否则提交该位点之后重启，尚未结束的分段数据会丢失
+func (cr *ContainerReader) limitEndOffset(event *Event) *Event {
+	for _, partial := range cr.partials {
+		if partial.Offset < event.EndOffset {
+			event.EndOffset = partial.Offset
+		}
+	}
+	return event
+}
+
+// flushOne 投递起始位点最小的尚未结束的分段数据，没有时返回 nil
+func (cr *ContainerReader) flushOne() *Event {
+	key := ""
+	for stream := range cr.partials {
+		if key == "" || cr.partials[stream].Offset < cr.partials[key].Offset {
+			key = stream
+		}
+	}
+	if key == "" {
+		return nil
+	}
+	ret := cr.partials[key]
+	delete(cr.partials, key)
+	return cr.limitEndOffset(ret)
+}
@@ -0,0 +1,157 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContainerReader(t *testing.T) {
+	dir := t.TempDir()
+	docker := filepath.Join(dir, "docker.log")
+	writeLines(t, docker,
+		`{"log":"hello\n","stream":"stdout","time":"2022-05-01T10:00:00.123456789Z"}`,
+		`{"log":"part1-","stream":"stderr","time":"2022-05-01T10:00:01Z"}`,
+		`{"log":"part2\n","stream":"stderr","time":"2022-05-01T10:00:01Z"}`,
+		`not a container line`,
+	)
+	cri := filepath.Join(dir, "cri.log")
+	writeLines(t, cri,
+		`2022-05-01T10:00:00.123456789Z stdout P out-1,`,
+		`2022-05-01T10:00:00.2Z stderr F err`,
+		`2022-05-01T10:00:00.3Z stdout F out-2`,
+		`2022-05-01T10:00:00.4Z stdout F `,
+	)
+	ts, _ := time.Parse(time.RFC3339Nano, "2022-05-01T10:00:00.123456789Z")
+
+	type expect struct {
+		content string
+		stream  string
+		flag    string
+	}
+	tests := []struct {
+		name   string
+		cfg    ContainerConfig
+		expect []expect
+	}{
+		{docker, ContainerConfig{Format: ContainerDocker}, []expect{
+			{"hello", StreamStdout, ""},
+			{"part1-part2", StreamStderr, ""},
+			{"not a container line", "", FlagContainerError},
+		}},
+		{cri, ContainerConfig{}, []expect{
+			{"err", StreamStderr, ""},
+			{"out-1,out-2", StreamStdout, ""},
+			{"", StreamStdout, ""},
+		}},
+		{cri, ContainerConfig{Stream: StreamStdout}, []expect{
+			{"out-1,out-2", StreamStdout, ""},
+			{"", StreamStdout, ""},
+		}},
+	}
+	for _, tt := range tests {
+		offset := int64(0)
+		lr, err := NewLineReaderWithConfig(tt.name, &offset, LineReaderConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := NewContainerReader(lr, tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prev int64
+		for i := range tt.expect {
+			event, err := reader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream, _ := event.GetField(FieldStream)
+			if event.String() != tt.expect[i].content || (stream != nil && stream != tt.expect[i].stream) {
+				t.Fatalf("%+v line %d expect=%+v, actual=%+v", tt.cfg, i, tt.expect[i], event)
+			}
+			if tt.expect[i].flag != "" && !event.HasFlag(tt.expect[i].flag) {
+				t.Fatalf("%+v line %d expect flag %s, actual=%v", tt.cfg, i, tt.expect[i].flag, event.Flags)
+			}
+			if at, _ := event.GetField(FieldTime); i == 0 && tt.name == docker && at != ts {
+				t.Fatalf("unexpect time field : %v", at)
+			}
+			// 提交的位点不能跳过其他输出流中尚未结束的分段数据
+			if event.EndOffset < prev {
+				t.Fatalf("end offset should not go backwards, prev=%d actual=%d", prev, event.EndOffset)
+			}
+			prev = event.EndOffset
+		}
+		if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+			t.Fatalf("expect EOF, actual=%v", err)
+		}
+		reader.Close()
+	}
+
+	if _, err := NewContainerReader(nil, ContainerConfig{Stream: "stdin"}); !errors.Is(err, ErrorContainerStream) {
+		t.Fatalf("expect ErrorContainerStream, actual=%v", err)
+	}
+}
+
+func TestHarvester_Container(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name,
+		`2022-05-01T10:00:00Z stderr P err-1,`,
+		`2022-05-01T10:00:00Z stdout F out-1`,
+	)
+
+	sink := &mockEventSink{}
+	beater := newTestHarvester(t, Config{
+		Path:      filepath.Join(dir, "app\\.log"),
+		MetaPath:  filepath.Join(dir, "meta"),
+		Container: &ContainerConfig{Format: ContainerCRI, Stream: StreamStderr},
+	})
+	beater.RegisterBatchSink(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+
+	// 分段数据的后续部分写入之后才会投递
+	time.Sleep(200 * time.Millisecond)
+	writeLines(t, name, `2022-05-01T10:00:01Z stderr F err-2`)
+	sink.waitFor(t, 1)
+	time.Sleep(200 * time.Millisecond)
+
+	events := sink.waitFor(t, 1)
+	if len(events) != 1 || events[0].String() != "err-1,err-2" {
+		t.Fatalf("unexpect events : %+v", events)
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events[0].Offset != 0 || events[0].EndOffset != stat.Size() {
+		t.Fatalf("unexpect offset : %d-%d", events[0].Offset, events[0].EndOffset)
+	}
+}
@@ -0,0 +1,318 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrorDissectTokenizer dissect 的 tokenizer 不合法
+	ErrorDissectTokenizer error = errors.New("invalid dissect tokenizer")
+	// ErrorDissectNoMatch 事件的内容与 tokenizer 不匹配
+	ErrorDissectNoMatch error = errors.New("dissect tokenizer not match")
+
+	// dissectField tokenizer 中的字段定义 %{...}
+	dissectField = regexp.MustCompile(`%\{([^}]*)\}`)
+)
+
+// dissectProcessor 按照 tokenizer 中的分隔符将事件的内容切分为字段，不使用正则表达式，适合格式固定的日志
+//
+//   - tokenizer：例如 `%{ts} [%{level}] %{module} - %{msg}`，字段之间必须有分隔符，最后一个字段为剩余的全部内容
+//   - field：从 Event.Fields 中的哪个字段切分，为空时切分 Event.Content
+//   - target：切分出的字段放在 Event.Fields 的哪个 key 下，为空时直接添加到 Event.Fields 的根
+//   - append_separator：追加字段时使用的分隔符，为空时默认为空格
+//
+// 字段支持以下修饰符：
+//
+//   - %{} 或者 %{?name}：跳过该字段
+//   - %{+name}、%{+name/2}：将内容追加到同名的字段中，/ 之后为追加的顺序
+//   - %{name->}：跳过该字段之后重复的分隔符，例如用于对齐的多个空格
+//   - %{name|int}：转换字段的类型，支持 int、long、float、double、bool、string
+type dissectProcessor struct {
+	prefix  []byte
+	fields  []dissectItem
+	appends []dissectAppend
+
+	field     string
+	target    string
+	separator string
+}
+
+// dissectItem tokenizer 中的一个字段
+type dissectItem struct {
+	name string
+	// delim 字段之后的分隔符，最后一个字段为空
+	delim    []byte
+	skip     bool
+	appended bool
+	// order 追加字段的顺序
+	order    int
+	rightPad bool
+	typ      string
+}
+
+// dissectAppend 需要追加在一起的字段
+type dissectAppend struct {
+	name string
+	// indexes 按照追加顺序排列的字段下标
+	indexes []int
+}
+
+func newDissectProcessor(options map[string]interface{}) (Processor, error) {
+	tokenizer, err := optionString(options, "tokenizer")
+	if err != nil {
+		return nil, err
+	}
+	p, err := compileDissect(tokenizer)
+	if err != nil {
+		return nil, err
+	}
+	if p.field, err = optionString(options, "field"); err != nil {
+		return nil, err
+	}
+	if p.target, err = optionString(options, "target"); err != nil {
+		return nil, err
+	}
+	if p.separator, err = optionString(options, "append_separator"); err != nil {
+		return nil, err
+	}
+	if p.separator == "" {
+		p.separator = " "
+	}
+	return p, nil
+}
+
+// compileDissect 解析 tokenizer
+//
+//	@param tokenizer
+//	@return *dissectProcessor
+//	@return error
+func compileDissect(tokenizer string) (*dissectProcessor, error) {
+	locs := dissectField.FindAllStringSubmatchIndex(tokenizer, -1)
+	if len(locs) == 0 {
+		return nil, ErrorDissectTokenizer
+	}
+	p := &dissectProcessor{
+		prefix: []byte(tokenizer[:locs[0][0]]),
+	}
+	for i, loc := range locs {
+		item, err := parseDissectItem(tokenizer[loc[2]:loc[3]])
+		if err != nil {
+			return nil, err
+		}
+		end := len(tokenizer)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+			// 相邻的两个字段之间没有分隔符时无法切分
+			if end == loc[1] {
+				return nil, ErrorDissectTokenizer
+			}
+		}
+		item.delim = []byte(tokenizer[loc[1]:end])
+		p.fields = append(p.fields, item)
+	}
+	p.compileAppends()
+	return p, nil
+}
+
+// compileAppends 预先计算追加字段的顺序，同名的普通字段为追加的第一部分
+func (p *dissectProcessor) compileAppends() {
+	for i := range p.fields {
+		item := &p.fields[i]
+		if !item.appended || p.findAppend(item.name) >= 0 {
+			continue
+		}
+		indexes := make([]int, 0, 2)
+		for j := range p.fields {
+			other := &p.fields[j]
+			if other.skip || other.name != item.name {
+				continue
+			}
+			// 普通字段作为第一部分，并且不再单独写入
+			if !other.appended {
+				other.appended = true
+				other.order = -1
+			}
+			indexes = append(indexes, j)
+		}
+		sort.SliceStable(indexes, func(a, b int) bool {
+			return p.fields[indexes[a]].order < p.fields[indexes[b]].order
+		})
+		p.appends = append(p.appends, dissectAppend{name: item.name, indexes: indexes})
+	}
+}
+
+// findAppend 查找追加字段的下标，不存在时返回 -1
+func (p *dissectProcessor) findAppend(name string) int {
+	for i := range p.appends {
+		if p.appends[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseDissectItem 解析字段的名称以及修饰符
+func parseDissectItem(key string) (dissectItem, error) {
+	item := dissectItem{}
+	if idx := strings.LastIndex(key, "|"); idx >= 0 {
+		item.typ = key[idx+1:]
+		key = key[:idx]
+		switch item.typ {
+		case "int", "long", "float", "double", "bool", "string":
+		default:
+			return item, ErrorDissectTokenizer
+		}
+	}
+	if strings.HasSuffix(key, "->") {
+		item.rightPad = true
+		key = strings.TrimSuffix(key, "->")
+	}
+	switch {
+	case key == "", strings.HasPrefix(key, "?"):
+		item.skip = true
+	case strings.HasPrefix(key, "+"):
+		item.appended = true
+		key = key[1:]
+		if idx := strings.LastIndex(key, "/"); idx >= 0 {
+			order, err := strconv.Atoi(key[idx+1:])
+			if err != nil {
+				return item, ErrorDissectTokenizer
+			}
+			item.order = order
+			key = key[:idx]
+		}
+	case strings.HasPrefix(key, "*"), strings.HasPrefix(key, "&"):
+		// 不支持使用其他字段的值作为字段名称
+		return item, ErrorDissectTokenizer
+	}
+	item.name = key
+	return item, nil
+}
+
+// Process
+func (p *dissectProcessor) Process(event *Event) ([]*Event, error) {
+	content := event.Content
+	if p.field != "" {
+		val, ok := event.Fields[p.field].(string)
+		if !ok {
+			return nil, ErrorDissectNoMatch
+		}
+		content = []byte(val)
+	}
+	bounds, err := p.dissect(content)
+	if err != nil {
+		return nil, err
+	}
+	// 所有字段都切分并转换成功之后再写入事件，避免失败时留下部分字段
+	values := make(map[string]interface{}, len(p.fields))
+	if err := p.write(content, bounds, values); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.target != "":
+		event.PutField(p.target, values)
+		return []*Event{event}, nil
+	case event.Fields == nil:
+		event.Fields = values
+		return []*Event{event}, nil
+	}
+	for k, v := range values {
+		event.PutField(k, v)
+	}
+	return []*Event{event}, nil
+}
+
+// dissect 按照分隔符切分内容，返回每个字段在内容中的起止位置
+func (p *dissectProcessor) dissect(content []byte) ([]int, error) {
+	if !bytes.HasPrefix(content, p.prefix) {
+		return nil, ErrorDissectNoMatch
+	}
+	pos := len(p.prefix)
+	bounds := make([]int, 2*len(p.fields))
+	for i := range p.fields {
+		item := &p.fields[i]
+		end := len(content)
+		if len(item.delim) > 0 {
+			idx := bytes.Index(content[pos:], item.delim)
+			if idx < 0 {
+				return nil, ErrorDissectNoMatch
+			}
+			end = pos + idx
+		}
+		bounds[2*i], bounds[2*i+1] = pos, end
+		pos = end + len(item.delim)
+		if item.rightPad {
+			for len(item.delim) > 0 && bytes.HasPrefix(content[pos:], item.delim) {
+				pos += len(item.delim)
+			}
+		}
+	}
+	return bounds, nil
+}
+
+// write 将切分出的字段写入 values
+func (p *dissectProcessor) write(content []byte, bounds []int, values map[string]interface{}) error {
+	for i := range p.fields {
+		item := &p.fields[i]
+		if item.skip || item.appended {
+			continue
+		}
+		val, err := convertDissectValue(string(content[bounds[2*i]:bounds[2*i+1]]), item.typ)
+		if err != nil {
+			return err
+		}
+		values[item.name] = val
+	}
+	for i := range p.appends {
+		var builder strings.Builder
+		for n, idx := range p.appends[i].indexes {
+			if n > 0 {
+				builder.WriteString(p.separator)
+			}
+			builder.Write(content[bounds[2*idx]:bounds[2*idx+1]])
+		}
+		values[p.appends[i].name] = builder.String()
+	}
+	return nil
+}
+
+// convertDissectValue 按照 %{name|type} 中的 type 转换字段的类型
+func convertDissectValue(val, typ string) (interface{}, error) {
+	switch typ {
+	case "int", "long":
+		return strconv.ParseInt(val, 10, 64)
+	case "float", "double":
+		return strconv.ParseFloat(val, 64)
+	case "bool":
+		return strconv.ParseBool(val)
+	default:
+		return val, nil
+	}
+}
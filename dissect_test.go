@@ -0,0 +1,135 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDissectProcessor(t *testing.T) {
+	tests := []struct {
+		options map[string]interface{}
+		line    string
+		expect  map[string]interface{}
+	}{
+		{
+			options: map[string]interface{}{"tokenizer": "%{ts} [%{level}] %{module} - %{msg}"},
+			line:    "2022-05-01T10:00:00Z [INFO] order - create order id=1 - ok",
+			expect:  map[string]interface{}{"ts": "2022-05-01T10:00:00Z", "level": "INFO", "module": "order", "msg": "create order id=1 - ok"},
+		},
+		{
+			// 跳过字段、追加字段以及对齐的空格
+			options: map[string]interface{}{"tokenizer": "%{+ts/2} %{+ts/1} %{?thread} %{level->} %{} %{msg}", "append_separator": "T"},
+			line:    "10:00:00 2022-05-01 main WARN    x disk full",
+			expect:  map[string]interface{}{"ts": "2022-05-01T10:00:00", "level": "WARN", "msg": "disk full"},
+		},
+		{
+			// 类型转换以及 target、field
+			options: map[string]interface{}{"tokenizer": "<%{status|int}> %{cost|float} %{cached|bool}", "field": "raw", "target": "req"},
+			line:    "<200> 0.5 true",
+			expect: map[string]interface{}{
+				"raw": "<200> 0.5 true",
+				"req": map[string]interface{}{"status": int64(200), "cost": 0.5, "cached": true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		processor, err := newDissectProcessor(tt.options)
+		if err != nil {
+			t.Fatalf("%v : %v", tt.options, err)
+		}
+		event := &Event{Content: []byte(tt.line)}
+		if tt.options["field"] != nil {
+			event.PutField("raw", tt.line)
+		}
+		if _, err := processor.Process(event); err != nil {
+			t.Fatalf("%v : %v", tt.options, err)
+		}
+		if !reflect.DeepEqual(event.Fields, tt.expect) {
+			t.Fatalf("%v expect=%v, actual=%v", tt.options, tt.expect, event.Fields)
+		}
+	}
+
+	processor, err := newDissectProcessor(map[string]interface{}{"tokenizer": "[%{level}] %{msg}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"INFO msg", "[INFO msg"} {
+		if _, err := processor.Process(&Event{Content: []byte(line)}); !errors.Is(err, ErrorDissectNoMatch) {
+			t.Fatalf("%s expect ErrorDissectNoMatch, actual=%v", line, err)
+		}
+	}
+	// 类型转换失败时不会留下部分字段
+	processor, err = newDissectProcessor(map[string]interface{}{"tokenizer": "%{a} %{b|int} %{c}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &Event{Content: []byte("x notint z")}
+	if _, err := processor.Process(event); err == nil || len(event.Fields) != 0 {
+		t.Fatalf("expect conversion error without fields, err=%v fields=%v", err, event.Fields)
+	}
+	for _, tokenizer := range []string{"", "%{a}%{b}", "%{a|time}", "%{*key} %{&key}", "%{+a/x} %{b}"} {
+		if _, err := newDissectProcessor(map[string]interface{}{"tokenizer": tokenizer}); !errors.Is(err, ErrorDissectTokenizer) {
+			t.Fatalf("%s expect ErrorDissectTokenizer, actual=%v", tokenizer, err)
+		}
+	}
+}
+
+const benchmarkLine = "2022-05-01 10:00:00.123 [INFO] order-service - create order id=1 user=alice cost=12ms"
+
+func BenchmarkDissectProcessor(b *testing.B) {
+	processor, err := newDissectProcessor(map[string]interface{}{
+		"tokenizer": "%{ts} %{+ts} [%{level}] %{module} - %{msg}",
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkProcessor(b, processor)
+}
+
+func BenchmarkGrokProcessor(b *testing.B) {
+	processor, err := newGrokProcessor(map[string]interface{}{
+		"patterns": []string{`^%{TIMESTAMP_ISO8601:ts} \[%{LOGLEVEL:level}\] %{NOTSPACE:module} - %{GREEDYDATA:msg}$`},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkProcessor(b, processor)
+}
+
+func benchmarkProcessor(b *testing.B, processor Processor) {
+	content := []byte(benchmarkLine)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event := &Event{Content: content}
+		if _, err := processor.Process(event); err != nil {
+			b.Fatal(err)
+		}
+		if len(event.Fields) != 4 {
+			b.Fatalf("unexpect fields : %v", event.Fields)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	xencoding "golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+const (
+	// EncodingUTF8 默认的编码，不需要进行转换
+	EncodingUTF8 = "utf-8"
+	// EncodingLatin1 ISO-8859-1
+	EncodingLatin1 = "latin1"
+	// EncodingUTF16LE 小端序的 UTF-16
+	EncodingUTF16LE = "utf-16le"
+	// EncodingUTF16BE 大端序的 UTF-16
+	EncodingUTF16BE = "utf-16be"
+	// EncodingGBK 简体中文 GBK
+	EncodingGBK = "gbk"
+	// EncodingGB18030 简体中文 GB18030
+	EncodingGB18030 = "gb18030"
+	// EncodingBig5 繁体中文 Big5
+	EncodingBig5 = "big5"
+)
+
+var (
+	// ErrorUnsupportedEncoding 没有注册该名称对应的编码
+	ErrorUnsupportedEncoding error = errors.New("unsupported encoding")
+
+	// ErrorInvalidEncoding 内容中存在无法按照配置的字符编码转换的字节
+	ErrorInvalidEncoding error = errors.New("invalid encoded content")
+
+	encodingLock sync.RWMutex
+	// encodings 编码名称（小写）对应的编码
+	encodings = map[string]*encoding{
+		EncodingUTF8: newASCIIEncoding(nil),
+		"utf8":       newASCIIEncoding(nil),
+		"plain":      newASCIIEncoding(nil),
+		EncodingLatin1: newASCIIEncoding(func() Decoder {
+			return decodeLatin1
+		}),
+		"iso-8859-1": newASCIIEncoding(func() Decoder {
+			return decodeLatin1
+		}),
+		EncodingGBK:     newTextEncoding(simplifiedchinese.GBK, nil),
+		EncodingGB18030: newTextEncoding(simplifiedchinese.GB18030, []byte{0x84, 0x31, 0xA4, 0x37}),
+		EncodingBig5:    newTextEncoding(traditionalchinese.Big5, nil),
+		EncodingUTF16LE: {
+			unit:    2,
+			newline: []byte{'\n', 0},
+			cr:      []byte{'\r', 0},
+			newDecoder: func() Decoder {
+				return func(raw []byte) ([]byte, error) {
+					return decodeUTF16(raw, false), nil
+				}
+			},
+		},
+		EncodingUTF16BE: {
+			unit:    2,
+			newline: []byte{0, '\n'},
+			cr:      []byte{0, '\r'},
+			newDecoder: func() Decoder {
+				return func(raw []byte) ([]byte, error) {
+					return decodeUTF16(raw, true), nil
+				}
+			},
+		},
+	}
+)
+
+// utf8RuneError utf8.RuneError 编码后的内容
+var utf8RuneError = []byte(string(utf8.RuneError))
+
+// Decoder 将一行原始数据转换为 UTF-8
+type Decoder func(raw []byte) ([]byte, error)
+
+// encoding 文件内容的字符编码
+type encoding struct {
+	// unit 编码单元的字节数，换行符需要按照编码单元对齐
+	unit int
+	// newline 换行符编码后的内容
+	newline []byte
+	// cr 回车符编码后的内容，行尾的回车符会被去掉
+	cr []byte
+	// newDecoder 为每个 Reader 构造独立的 Decoder，为 nil 时表示不需要转换
+	newDecoder func() Decoder
+}
+
+// newASCIIEncoding 换行符与 ASCII 相同的编码
+func newASCIIEncoding(newDecoder func() Decoder) *encoding {
+	return &encoding{
+		unit:       1,
+		newline:    []byte{'\n'},
+		cr:         []byte{'\r'},
+		newDecoder: newDecoder,
+	}
+}
+
+// newTextEncoding 基于 golang.org/x/text 实现的多字节编码，这些编码中多字节字符的每个字节都不会与换行符相同
+// golang.org/x/text 的 Decoder 会将无法转换的字节替换为 U+FFFD 而不是返回错误，因此转换结果中多出的 U+FFFD 表示存在无法转换的内容
+//
+//	@param enc
+//	@param replacement U+FFFD 本身在该编码中的内容，无法表示 U+FFFD 时为 nil
+//	@return *encoding
+func newTextEncoding(enc xencoding.Encoding, replacement []byte) *encoding {
+	return newASCIIEncoding(func() Decoder {
+		decoder := enc.NewDecoder()
+		return func(raw []byte) ([]byte, error) {
+			decoded, err := decoder.Bytes(raw)
+			if err != nil {
+				return nil, err
+			}
+			if n := bytes.Count(decoded, utf8RuneError); n > 0 {
+				if len(replacement) == 0 || n > bytes.Count(raw, replacement) {
+					return nil, ErrorInvalidEncoding
+				}
+			}
+			return decoded, nil
+		}
+	})
+}
+
+// delim 换行符的最后一个字节，用于查找行尾
+func (enc *encoding) delim() byte {
+	return enc.newline[len(enc.newline)-1]
+}
+
+// RegisterEncoding 注册换行符与 ASCII 相同的编码，例如 Shift_JIS、EUC-KR
+// 可以使用 golang.org/x/text/encoding 中的实现，例如：
+//
+//	filebeat.RegisterEncoding("shift_jis", func() filebeat.Decoder {
+//		return japanese.ShiftJIS.NewDecoder().Bytes
+//	})
+//
+//	@param name 编码名称，不区分大小写
+//	@param newDecoder 为每个 Reader 构造独立的 Decoder
+func RegisterEncoding(name string, newDecoder func() Decoder) {
+	encodingLock.Lock()
+	defer encodingLock.Unlock()
+
+	encodings[strings.ToLower(name)] = newASCIIEncoding(newDecoder)
+}
+
+// findEncoding 根据名称查找编码，名称为空时默认为 UTF-8
+func findEncoding(name string) (*encoding, error) {
+	if name == "" {
+		name = EncodingUTF8
+	}
+	encodingLock.RLock()
+	defer encodingLock.RUnlock()
+
+	enc, ok := encodings[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrorUnsupportedEncoding
+	}
+	return enc, nil
+}
+
+// decodeLatin1 ISO-8859-1 的每个字节都对应同样码点的字符
+func decodeLatin1(raw []byte) ([]byte, error) {
+	ret := make([]byte, 0, len(raw))
+	for _, b := range raw {
+		ret = utf8.AppendRune(ret, rune(b))
+	}
+	return ret, nil
+}
+
+// decodeUTF16 将 UTF-16 转换为 UTF-8，多余的单个字节会被转换为 utf8.RuneError
+func decodeUTF16(raw []byte, bigEndian bool) []byte {
+	units := make([]uint16, 0, len(raw)/2)
+	for i := 0; i+1 < len(raw); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		} else {
+			units = append(units, uint16(raw[i+1])<<8|uint16(raw[i]))
+		}
+	}
+
+	ret := make([]byte, 0, len(raw))
+	for _, r := range utf16.Decode(units) {
+		ret = utf8.AppendRune(ret, r)
+	}
+	if len(raw)%2 != 0 {
+		ret = utf8.AppendRune(ret, utf8.RuneError)
+	}
+	return ret
+}
+
+// PathEncoding 按照文件路径指定字符编码
+type PathEncoding struct {
+	// Pattern 文件路径的 glob 规则，与 Config.Paths 相同
+	Pattern string
+	// Encoding 编码名称，支持 utf-8、latin1、utf-16le、utf-16be、gbk、gb18030、big5 以及通过 RegisterEncoding 注册的编码
+	Encoding string
+}
+
+// encodingRule 编译后的 PathEncoding
+type encodingRule struct {
+	pattern  *globPattern
+	encoding string
+}
+
+// newEncodingRules 编译 Config.Encodings，并检查编码是否支持
+func newEncodingRules(items []PathEncoding) ([]encodingRule, error) {
+	rules := make([]encodingRule, 0, len(items))
+	for i := range items {
+		pattern, err := newGlobPattern(items[i].Pattern)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := findEncoding(items[i].Encoding); err != nil {
+			return nil, err
+		}
+		rules = append(rules, encodingRule{
+			pattern:  pattern,
+			encoding: items[i].Encoding,
+		})
+	}
+	return rules, nil
+}
+
+// encodingFor 获取文件的字符编码
+//
+//	@receiver beater
+//	@param path
+//	@return string
+func (beater *harvester) encodingFor(path string) string {
+	for i := range beater.encodingRules {
+		if beater.encodingRules[i].pattern.match(path) {
+			return beater.encodingRules[i].encoding
+		}
+	}
+	return beater.cfg.LineReader.Encoding
+}
@@ -0,0 +1,80 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestHarvester_Encodings(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.log"), []byte("caf\xe9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "win.log"), []byte{0xFF, 0xFE, 'w', 0, '=', 0, 0x0A, 0x01, '\r', 0, '\n', 0}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Paths:    []string{filepath.Join(dir, "*.log")},
+		MetaPath: filepath.Join(dir, "meta"),
+		LineReader: LineReaderConfig{
+			Encoding: EncodingLatin1,
+		},
+		Encodings: []PathEncoding{
+			{Pattern: filepath.Join(dir, "win.log"), Encoding: EncodingUTF16LE},
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 2)
+
+	actual := sink.messages()
+	sort.Strings(actual)
+	if len(actual) != 2 || actual[0] != "café" || actual[1] != "w=Ċ" {
+		t.Fatalf("unexpect messages : %v", actual)
+	}
+}
+
+func TestNewEncodingRules(t *testing.T) {
+	if _, err := newEncodingRules([]PathEncoding{{Pattern: "/var/log/*.log", Encoding: "unknown"}}); err == nil {
+		t.Fatal("expect unsupported encoding error")
+	}
+	rules, err := newEncodingRules([]PathEncoding{{Pattern: "/var/log/*.log", Encoding: EncodingUTF16BE}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	beater := &harvester{encodingRules: rules}
+	if enc := beater.encodingFor("/var/log/app.log"); enc != EncodingUTF16BE {
+		t.Fatalf("expect=%s actual=%s", EncodingUTF16BE, enc)
+	}
+	if enc := beater.encodingFor("/tmp/app.log"); enc != "" {
+		t.Fatalf("expect default encoding, actual=%s", enc)
+	}
+}
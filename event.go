@@ -0,0 +1,125 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"time"
+)
+
+const (
+	// FlagMultiline 事件由多行数据合并而成
+	FlagMultiline = "multiline"
+	// FlagTruncated 事件的内容被截断过
+	FlagTruncated = "truncated"
+	// FlagEncodingError 事件的内容无法按照配置的字符编码转换，保留了原始内容
+	FlagEncodingError = "encoding_error"
+	// FlagJSONError 事件的内容无法按照 JSONConfig 解析，保留了原始内容
+	FlagJSONError = "json_error"
+	// FlagContainerError 事件的内容不符合 ContainerConfig 的容器日志格式，保留了原始内容
+	FlagContainerError = "container_error"
+	// FlagProcessorError 事件经过的某个 Processor 处理失败
+	FlagProcessorError = "processor_error"
+	// FlagTimestampError timestamp Processor 无法解析事件发生的时间
+	FlagTimestampError = "timestamp_error"
+)
+
+// Event 从文件中读取到的一条数据
+type Event struct {
+	// Content 原始数据，不包含行尾的换行符
+	Content []byte
+	// Source 数据所在的文件路径
+	Source string
+	// State 数据所在文件的 INode 信息
+	State StateOS
+	// Offset 数据在文件中的起始位点
+	Offset int64
+	// EndOffset 数据在文件中的结束位点，即下一条数据的起始位点
+	EndOffset int64
+	// ReadTime 读取到该数据的时间
+	ReadTime time.Time
+	// Timestamp 事件发生的时间，由 timestamp Processor 从数据中解析，为零值时表示未知
+	Timestamp time.Time
+	// Fields 扩展字段
+	Fields map[string]interface{}
+	// Flags 事件的标记信息，例如 FlagMultiline、FlagTruncated
+	Flags []string
+}
+
+// String 返回数据的文本内容
+//
+//	@receiver e
+//	@return string
+func (e *Event) String() string {
+	return string(e.Content)
+}
+
+// PutField 设置扩展字段
+//
+//	@receiver e
+//	@param key
+//	@param value
+func (e *Event) PutField(key string, value interface{}) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]interface{})
+	}
+	e.Fields[key] = value
+}
+
+// GetField 获取扩展字段
+//
+//	@receiver e
+//	@param key
+//	@return interface{}
+//	@return bool
+func (e *Event) GetField(key string) (interface{}, bool) {
+	if e.Fields == nil {
+		return nil, false
+	}
+	val, ok := e.Fields[key]
+	return val, ok
+}
+
+// AddFlag 添加标记信息，重复的标记只会保留一个
+//
+//	@receiver e
+//	@param flag
+func (e *Event) AddFlag(flag string) {
+	if e.HasFlag(flag) {
+		return
+	}
+	e.Flags = append(e.Flags, flag)
+}
+
+// HasFlag 判断是否存在指定的标记信息
+//
+//	@receiver e
+//	@param flag
+//	@return bool
+func (e *Event) HasFlag(flag string) bool {
+	for i := range e.Flags {
+		if e.Flags[i] == flag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"os"
+	"regexp"
+)
+
+// lineFilter 按照 Config.IncludeLines、Config.ExcludeLines 过滤数据
+type lineFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newLineFilter 编译过滤数据的正则表达式
+//
+//	@param include
+//	@param exclude
+//	@return *lineFilter
+//	@return error
+func newLineFilter(include, exclude []string) (*lineFilter, error) {
+	filter := &lineFilter{}
+	for i := range include {
+		reg, err := regexp.Compile(include[i])
+		if err != nil {
+			return nil, err
+		}
+		filter.include = append(filter.include, reg)
+	}
+	for i := range exclude {
+		reg, err := regexp.Compile(exclude[i])
+		if err != nil {
+			return nil, err
+		}
+		filter.exclude = append(filter.exclude, reg)
+	}
+	return filter, nil
+}
+
+// empty 是否没有配置任何过滤规则
+func (filter *lineFilter) empty() bool {
+	return len(filter.include) == 0 && len(filter.exclude) == 0
+}
+
+// accept 判断数据是否需要投递：配置了 include 时至少需要匹配其中一个，并且不能匹配任何一个 exclude
+//
+//	@receiver filter
+//	@param content
+//	@return bool
+func (filter *lineFilter) accept(content []byte) bool {
+	if len(filter.include) > 0 && !matchAny(filter.include, content) {
+		return false
+	}
+	return !matchAny(filter.exclude, content)
+}
+
+func matchAny(regs []*regexp.Regexp, content []byte) bool {
+	for i := range regs {
+		if regs[i].Match(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterReader 按照 lineFilter 丢弃读取到的行，在 JSON 解析以及多行合并之前执行
+// 被丢弃的行不会返回，位点随着之后返回的数据一起前进
+type filterReader struct {
+	reader Reader
+	filter *lineFilter
+	// onDrop 每丢弃一行数据回调一次
+	onDrop func()
+}
+
+// newFilterReader
+//
+//	@param reader
+//	@param filter
+//	@param onDrop
+//	@return Reader
+func newFilterReader(reader Reader, filter *lineFilter, onDrop func()) Reader {
+	return &filterReader{
+		reader: reader,
+		filter: filter,
+		onDrop: onDrop,
+	}
+}
+
+// CurFile
+func (fr *filterReader) CurFile() *os.File {
+	return fr.reader.CurFile()
+}
+
+// Offset
+func (fr *filterReader) Offset() int64 {
+	return fr.reader.Offset()
+}
+
+// Close
+func (fr *filterReader) Close() error {
+	return fr.reader.Close()
+}
+
+// Next
+func (fr *filterReader) Next() (*Event, error) {
+	for {
+		event, err := fr.reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if fr.filter.accept(event.Content) {
+			return event, nil
+		}
+		fr.onDrop()
+	}
+}
@@ -0,0 +1,126 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineFilter(t *testing.T) {
+	tests := []struct {
+		include []string
+		exclude []string
+		line    string
+		expect  bool
+	}{
+		{nil, nil, "DEBUG msg", true},
+		{nil, []string{"^DEBUG"}, "DEBUG msg", false},
+		{nil, []string{"^DEBUG"}, "INFO msg", true},
+		{[]string{"^ERR", "^WARN"}, nil, "WARN msg", true},
+		{[]string{"^ERR", "^WARN"}, nil, "INFO msg", false},
+		{[]string{"^ERR"}, []string{"timeout"}, "ERR timeout", false},
+	}
+	for _, tt := range tests {
+		filter, err := newLineFilter(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := filter.accept([]byte(tt.line)); actual != tt.expect {
+			t.Fatalf("include=%v exclude=%v line=%s expect=%v actual=%v", tt.include, tt.exclude, tt.line, tt.expect, actual)
+		}
+	}
+
+	if _, err := newLineFilter([]string{"("}, nil); err == nil {
+		t.Fatal("expect invalid regexp error")
+	}
+}
+
+func TestHarvester_FilterLines(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "INFO line=1", "DEBUG line=2", "ERROR line=3", "TRACE line=4", "DEBUG line=5")
+
+	sink := &mockSink{}
+	beater := newTestHarvester(t, Config{
+		Path:         filepath.Join(dir, "app\\.log"),
+		MetaPath:     filepath.Join(dir, "meta"),
+		IncludeLines: []string{"^(INFO|ERROR|DEBUG)"},
+		ExcludeLines: []string{"^DEBUG"},
+	}).(*harvester)
+	beater.RegisterSink(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 2)
+
+	if msgs := sink.messages(); strings.Join(msgs, ",") != "INFO line=1,ERROR line=3" {
+		t.Fatalf("unexpect messages : %v", msgs)
+	}
+	// 被过滤的最后一行的位点依然会被提交
+	stat, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		state, _ := beater.registry.Get(GetOSState(stat).String())
+		return state.Offset == stat.Size()
+	})
+	if dropped := beater.Stats().DroppedLines; dropped != 3 {
+		t.Fatalf("expect 3 dropped lines, actual=%d", dropped)
+	}
+}
+
+func TestHarvester_FilterLinesMultiline(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "ERROR line=1", "  at a", "DEBUG line=2", "  at b", "INFO line=3")
+
+	sink := &mockSink{}
+	beater := newTestHarvester(t, Config{
+		Path:         filepath.Join(dir, "app\\.log"),
+		MetaPath:     filepath.Join(dir, "meta"),
+		ExcludeLines: []string{"^DEBUG"},
+		Multiline: &MultilineConfig{
+			Pattern:      `^\s`,
+			FlushTimeout: 50 * time.Millisecond,
+		},
+	}).(*harvester)
+	beater.RegisterSink(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 2)
+
+	// 按照每一行过滤之后再进行多行合并
+	if msgs := sink.messages(); strings.Join(msgs, ",") != "ERROR line=1\n  at a\n  at b,INFO line=3" {
+		t.Fatalf("unexpect messages : %q", msgs)
+	}
+	if dropped := beater.Stats().DroppedLines; dropped != 1 {
+		t.Fatalf("expect 1 dropped line, actual=%d", dropped)
+	}
+}
@@ -0,0 +1,311 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// globRecursive 匹配任意层级的目录
+	globRecursive = "**"
+)
+
+var (
+	// ErrorEmptyPaths 没有配置任何需要采集的文件
+	ErrorEmptyPaths error = errors.New("path or paths must be set")
+)
+
+// pathPattern 文件路径的匹配规则
+type pathPattern interface {
+	// baseDir 需要扫描的根目录
+	baseDir() string
+	// maxDepth 相对于 baseDir 的最大扫描深度，< 0 表示不限制
+	maxDepth() int
+	// match 判断文件路径是否匹配
+	match(path string) bool
+}
+
+// globPattern shell glob 风格的匹配规则，支持使用 ** 匹配任意层级的目录
+type globPattern struct {
+	base     string
+	segments []string
+	depth    int
+}
+
+// newGlobPattern 解析 glob 匹配规则
+func newGlobPattern(pattern string) (*globPattern, error) {
+	segments := splitPath(filepath.Clean(pattern))
+	for i := range segments {
+		if segments[i] == globRecursive {
+			continue
+		}
+		// 提前检查语法是否正确
+		if _, err := filepath.Match(segments[i], ""); err != nil {
+			return nil, err
+		}
+	}
+
+	pos := 0
+	for pos < len(segments) && segments[pos] != globRecursive && !hasGlobMeta(segments[pos]) {
+		pos++
+	}
+	// 最后一段即使没有通配符也是文件名，不属于根目录
+	if pos == len(segments) {
+		pos--
+	}
+
+	depth := len(segments) - pos
+	for i := pos; i < len(segments); i++ {
+		if segments[i] == globRecursive {
+			depth = -1
+			break
+		}
+	}
+	return &globPattern{
+		base:     joinPath(segments[:pos]),
+		segments: segments,
+		depth:    depth,
+	}, nil
+}
+
+func (g *globPattern) baseDir() string {
+	return g.base
+}
+
+func (g *globPattern) maxDepth() int {
+	return g.depth
+}
+
+func (g *globPattern) match(path string) bool {
+	return matchSegments(g.segments, splitPath(filepath.Clean(path)))
+}
+
+// regexPattern 兼容 Config.Path 的匹配规则，目录需要完全匹配，文件名按照正则进行匹配
+type regexPattern struct {
+	dir  string
+	regx *regexp.Regexp
+}
+
+// newRegexPattern 解析 Config.Path
+func newRegexPattern(path string) (*regexPattern, error) {
+	regx, err := regexp.Compile(filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	return &regexPattern{
+		dir:  filepath.Dir(path),
+		regx: regx,
+	}, nil
+}
+
+func (r *regexPattern) baseDir() string {
+	return r.dir
+}
+
+func (r *regexPattern) maxDepth() int {
+	return 1
+}
+
+func (r *regexPattern) match(path string) bool {
+	return filepath.Dir(path) == r.dir && r.regx.MatchString(filepath.Base(path))
+}
+
+// fileMatcher 根据 include 以及 exclude 规则查找需要采集的文件
+type fileMatcher struct {
+	includes []pathPattern
+	excludes []*globPattern
+}
+
+// newFileMatcher 解析 Config 中的文件匹配规则
+func newFileMatcher(cfg Config) (*fileMatcher, error) {
+	matcher := &fileMatcher{}
+	if cfg.Path != "" {
+		pattern, err := newRegexPattern(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		matcher.includes = append(matcher.includes, pattern)
+	}
+	for i := range cfg.Paths {
+		pattern, err := newGlobPattern(cfg.Paths[i])
+		if err != nil {
+			return nil, err
+		}
+		matcher.includes = append(matcher.includes, pattern)
+	}
+	for i := range cfg.Exclude {
+		pattern, err := newGlobPattern(cfg.Exclude[i])
+		if err != nil {
+			return nil, err
+		}
+		matcher.excludes = append(matcher.excludes, pattern)
+	}
+	if len(matcher.includes) == 0 {
+		return nil, ErrorEmptyPaths
+	}
+	return matcher, nil
+}
+
+// match 判断文件是否需要被采集
+func (m *fileMatcher) match(path string) bool {
+	for i := range m.excludes {
+		if m.excludes[i].match(path) {
+			return false
+		}
+	}
+	for i := range m.includes {
+		if m.includes[i].match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// scan 扫描所有需要采集的文件，同时返回扫描过的目录，用于监听目录下文件的变化
+//
+//	@receiver m
+//	@return []fileInfo
+//	@return []string
+//	@return error
+func (m *fileMatcher) scan() ([]fileInfo, []string, error) {
+	var (
+		files = make([]fileInfo, 0)
+		dirs  = make([]string, 0)
+		seen  = map[string]struct{}{}
+	)
+
+	for i := range m.includes {
+		pattern := m.includes[i]
+		base := pattern.baseDir()
+		err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// 根目录不存在或者子目录没有权限时，忽略即可
+				if path != base && d != nil && d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if depth := pathDepth(base, path); pattern.maxDepth() >= 0 && depth >= pattern.maxDepth() {
+					return filepath.SkipDir
+				}
+				if _, ok := seen[path]; !ok {
+					seen[path] = struct{}{}
+					dirs = append(dirs, path)
+				}
+				return nil
+			}
+			if !pattern.match(path) || !m.match(path) {
+				return nil
+			}
+			if _, ok := seen[path]; ok {
+				return nil
+			}
+			// 软链接以其指向的文件为准
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				// 文件在扫描的过程中被删除了，或者不是普通文件
+				return nil
+			}
+			seen[path] = struct{}{}
+			files = append(files, fileInfo{FileInfo: info, path: path})
+			return nil
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+	return files, dirs, nil
+}
+
+// fileInfo 扫描到的需要采集的文件
+type fileInfo struct {
+	os.FileInfo
+	// path 文件的完整路径
+	path string
+	// state 文件的 I-Node 信息
+	state StateOS
+	// key 文件在 Registry 中的 key，由 Config.FileIdentity 决定
+	key string
+	// fingerprint FileIdentity 为 fingerprint 时文件内容的指纹
+	fingerprint string
+}
+
+// matchSegments 按照路径的每一段进行匹配，** 可以匹配任意层级（包括 0 层）的目录
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == globRecursive {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// hasGlobMeta 判断是否包含 glob 通配符
+func hasGlobMeta(segment string) bool {
+	return strings.ContainsAny(segment, `*?[\`)
+}
+
+// splitPath 将路径按照分隔符拆分，绝对路径的第一段为空字符串
+func splitPath(path string) []string {
+	return strings.Split(path, string(filepath.Separator))
+}
+
+// joinPath splitPath 的逆操作
+func joinPath(segments []string) string {
+	if len(segments) == 0 {
+		return "."
+	}
+	if len(segments) == 1 && segments[0] == "" {
+		return string(filepath.Separator)
+	}
+	return strings.Join(segments, string(filepath.Separator))
+}
+
+// pathDepth 计算 path 相对于 base 的层级，base 本身为 0
+func pathDepth(base, path string) int {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return len(splitPath(rel))
+}
@@ -0,0 +1,168 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestGlobPattern_Match(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		expect  bool
+	}{
+		{"/var/log/*.log", "/var/log/app.log", true},
+		{"/var/log/*.log", "/var/log/app/app.log", false},
+		{"/var/log/*/app-*.log", "/var/log/order/app-1.log", true},
+		{"/var/log/*/app-*.log", "/var/log/app-1.log", false},
+		{"/var/log/**/*.log", "/var/log/app.log", true},
+		{"/var/log/**/*.log", "/var/log/a/b/c/app.log", true},
+		{"/var/log/**/*.log", "/var/log/a/b/c/app.txt", false},
+		{"/var/log/**", "/var/log/a/b", true},
+		{"/var/**/tmp/*.log", "/var/a/tmp/x.log", true},
+		{"/var/**/tmp/*.log", "/var/a/b/x.log", false},
+	}
+	for _, tt := range tests {
+		pattern, err := newGlobPattern(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := pattern.match(tt.path); actual != tt.expect {
+			t.Errorf("pattern=%s path=%s expect=%v actual=%v", tt.pattern, tt.path, tt.expect, actual)
+		}
+	}
+}
+
+func TestGlobPattern_BaseDir(t *testing.T) {
+	tests := []struct {
+		pattern string
+		base    string
+		depth   int
+	}{
+		{"/var/log/app.log", "/var/log", 1},
+		{"/var/log/*.log", "/var/log", 1},
+		{"/var/log/*/app-*.log", "/var/log", 2},
+		{"/var/log/**/*.log", "/var/log", -1},
+		{"/*.log", "/", 1},
+		{"*.log", ".", 1},
+	}
+	for _, tt := range tests {
+		pattern, err := newGlobPattern(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pattern.baseDir() != tt.base || pattern.maxDepth() != tt.depth {
+			t.Errorf("pattern=%s expect=(%s, %d) actual=(%s, %d)", tt.pattern, tt.base, tt.depth, pattern.baseDir(), pattern.maxDepth())
+		}
+	}
+
+	if _, err := newGlobPattern("/var/log/[.log"); err == nil {
+		t.Fatal("bad pattern should return error")
+	}
+}
+
+func TestFileMatcher_Scan(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"app.log",
+		"app.txt",
+		"order/app.log",
+		"order/debug.log",
+		"order/2022/app.log",
+		"pay/nested/deep/app.log",
+	} {
+		mkdirAll(t, filepath.Dir(filepath.Join(dir, name)))
+		writeLines(t, filepath.Join(dir, name), "line")
+	}
+	if err := os.Symlink(filepath.Join(dir, "app.log"), filepath.Join(dir, "link.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	matcher, err := newFileMatcher(Config{
+		Paths:   []string{filepath.Join(dir, "**", "*.log")},
+		Exclude: []string{filepath.Join(dir, "**", "debug.log"), filepath.Join(dir, "pay", "**")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, dirs, err := matcher.scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := make([]string, 0, len(files))
+	for i := range files {
+		rel, _ := filepath.Rel(dir, files[i].path)
+		actual = append(actual, rel)
+	}
+	sort.Strings(actual)
+	expect := []string{"app.log", "link.log", "order/2022/app.log", "order/app.log"}
+	if len(actual) != len(expect) {
+		t.Fatalf("expect=%v actual=%v", expect, actual)
+	}
+	for i := range expect {
+		if actual[i] != filepath.FromSlash(expect[i]) {
+			t.Fatalf("expect=%v actual=%v", expect, actual)
+		}
+	}
+	// 递归匹配时需要监听所有子目录
+	if len(dirs) != 6 {
+		t.Fatalf("all directories should be watched, actual=%v", dirs)
+	}
+}
+
+func TestFileMatcher_LegacyPath(t *testing.T) {
+	dir := t.TempDir()
+	writeLines(t, filepath.Join(dir, "app.log"), "line")
+	mkdirAll(t, filepath.Join(dir, "sub"))
+	writeLines(t, filepath.Join(dir, "sub", "app.log"), "line")
+
+	matcher, err := newFileMatcher(Config{Path: filepath.Join(dir, ".*\\.log$")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, dirs, err := matcher.scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].path != filepath.Join(dir, "app.log") {
+		t.Fatalf("only files under the parent directory should match, actual=%v", files)
+	}
+	if len(dirs) != 1 || dirs[0] != dir {
+		t.Fatalf("only the parent directory should be watched, actual=%v", dirs)
+	}
+
+	if _, err := newFileMatcher(Config{}); err != ErrorEmptyPaths {
+		t.Fatalf("expect ErrorEmptyPaths, actual=%v", err)
+	}
+}
+
+func mkdirAll(t *testing.T, dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
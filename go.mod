@@ -2,6 +2,8 @@ module github.com/chuntaojun/easy-filebeat
 
 go 1.19
 
-require github.com/sirupsen/logrus v1.9.0
-
-require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+require (
+	github.com/sirupsen/logrus v1.9.0
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8
+	golang.org/x/text v0.16.0
+)
@@ -0,0 +1,292 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// grokMaxDepth grok 规则最多的嵌套层数，用于发现循环引用
+	grokMaxDepth = 64
+	// grokGroupPrefix 展开规则时分配的命名分组的前缀，字段名称中可能包含正则表达式分组不支持的字符
+	grokGroupPrefix = "_grok"
+)
+
+var (
+	// ErrorGrokPattern grok 规则引用了不存在的规则、存在循环引用或者类型不支持
+	ErrorGrokPattern error = errors.New("invalid grok pattern")
+	// ErrorGrokNoMatch 所有的 grok 规则都不匹配
+	ErrorGrokNoMatch error = errors.New("grok patterns not match")
+
+	// grokReference %{SYNTAX}、%{SYNTAX:SEMANTIC} 以及 %{SYNTAX:SEMANTIC:TYPE}
+	grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@\[\]-]+))?(?::(\w+))?\}`)
+	// grokNamedGroup 规则中直接定义的命名分组 (?<name>...)
+	grokNamedGroup = regexp.MustCompile(`\(\?<([\w.@\[\]-]+)>`)
+)
+
+// grokProcessor 按照 grok 规则从事件的内容中提取字段，按照顺序使用第一个匹配的规则
+//
+//   - patterns：grok 规则列表，[]string，例如 %{COMBINEDAPACHELOG}
+//   - pattern_definitions：自定义的规则，map[string]interface{}，优先级高于 pattern_files 以及内置的规则
+//   - pattern_files：自定义规则的文件列表，[]string，每行为一个 `NAME PATTERN`，# 开头的行为注释
+//   - field：从 Event.Fields 中的哪个字段提取，为空时从 Event.Content 中提取
+//   - target：提取出的字段放在 Event.Fields 的哪个 key 下，为空时直接添加到 Event.Fields 的根
+//
+// %{SYNTAX:SEMANTIC:TYPE} 中的 TYPE 支持 int 以及 float，分别转换为 int64 以及 float64，没有匹配到的可选字段不会被添加
+type grokProcessor struct {
+	patterns []*grokPattern
+	field    string
+	target   string
+}
+
+// grokPattern 编译后的 grok 规则
+type grokPattern struct {
+	reg      *regexp.Regexp
+	captures []grokCapture
+}
+
+// grokCapture 正则表达式中的一个命名分组
+type grokCapture struct {
+	index int
+	name  string
+	typ   string
+}
+
+func newGrokProcessor(options map[string]interface{}) (Processor, error) {
+	patterns, err := optionStrings(options, "patterns")
+	if err != nil {
+		return nil, err
+	}
+	definitions := make(map[string]string, len(grokPatterns))
+	for k, v := range grokPatterns {
+		definitions[k] = v
+	}
+	if _, ok := options["pattern_files"]; ok {
+		files, err := optionStrings(options, "pattern_files")
+		if err != nil {
+			return nil, err
+		}
+		for i := range files {
+			if err := loadGrokPatterns(files[i], definitions); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if custom, ok := options["pattern_definitions"]; ok {
+		items, ok := custom.(map[string]interface{})
+		if !ok {
+			return nil, ErrorProcessorOptions
+		}
+		for k, v := range items {
+			str, ok := v.(string)
+			if !ok {
+				return nil, ErrorProcessorOptions
+			}
+			definitions[k] = str
+		}
+	}
+
+	p := &grokProcessor{}
+	if p.field, err = optionString(options, "field"); err != nil {
+		return nil, err
+	}
+	if p.target, err = optionString(options, "target"); err != nil {
+		return nil, err
+	}
+	for i := range patterns {
+		pattern, err := compileGrok(patterns[i], definitions)
+		if err != nil {
+			return nil, err
+		}
+		p.patterns = append(p.patterns, pattern)
+	}
+	return p, nil
+}
+
+// loadGrokPatterns 从文件中加载自定义的 grok 规则
+//
+//	@param name
+//	@param definitions
+//	@return error
+func loadGrokPatterns(name string, definitions map[string]string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items := strings.SplitN(line, " ", 2)
+		if len(items) != 2 {
+			return ErrorGrokPattern
+		}
+		definitions[items[0]] = strings.TrimSpace(items[1])
+	}
+	return scanner.Err()
+}
+
+// grokCompiler 将 grok 规则展开为正则表达式
+type grokCompiler struct {
+	definitions map[string]string
+	captures    []grokCapture
+}
+
+// compileGrok 编译 grok 规则
+//
+//	@param pattern
+//	@param definitions
+//	@return *grokPattern
+//	@return error
+func compileGrok(pattern string, definitions map[string]string) (*grokPattern, error) {
+	c := &grokCompiler{definitions: definitions}
+	expr, err := c.expand(pattern, 0)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	ret := &grokPattern{reg: reg}
+	for i, name := range reg.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		capture := grokCapture{name: name}
+		// 展开时分配的分组名称为 captures 中的下标，其他的为规则中直接定义的 (?P<name>...)
+		if idx, err := strconv.Atoi(strings.TrimPrefix(name, grokGroupPrefix)); err == nil &&
+			strings.HasPrefix(name, grokGroupPrefix) && idx < len(c.captures) {
+			capture = c.captures[idx]
+		}
+		capture.index = i
+		ret.captures = append(ret.captures, capture)
+	}
+	return ret, nil
+}
+
+// expand 递归展开规则中引用的其他规则，需要提取的字段转换为命名分组
+func (c *grokCompiler) expand(pattern string, depth int) (string, error) {
+	if depth > grokMaxDepth {
+		return "", ErrorGrokPattern
+	}
+	var err error
+	ret := grokNamedGroup.ReplaceAllStringFunc(pattern, func(match string) string {
+		name := grokNamedGroup.FindStringSubmatch(match)[1]
+		return "(?P<" + c.capture(name, "") + ">"
+	})
+	ret = grokReference.ReplaceAllStringFunc(ret, func(match string) string {
+		if err != nil {
+			return ""
+		}
+		items := grokReference.FindStringSubmatch(match)
+		name, semantic, typ := items[1], items[2], items[3]
+		definition, ok := c.definitions[name]
+		if !ok || (typ != "" && typ != "int" && typ != "float") {
+			err = ErrorGrokPattern
+			return ""
+		}
+		var group string
+		if semantic != "" {
+			// 先分配分组，保证外层字段的分组在内层字段之前
+			group = c.capture(semantic, typ)
+		}
+		inner, e := c.expand(definition, depth+1)
+		if e != nil {
+			err = e
+			return ""
+		}
+		if group == "" {
+			return "(?:" + inner + ")"
+		}
+		return "(?P<" + group + ">" + inner + ")"
+	})
+	return ret, err
+}
+
+// capture 分配一个命名分组
+func (c *grokCompiler) capture(name, typ string) string {
+	c.captures = append(c.captures, grokCapture{name: name, typ: typ})
+	return grokGroupPrefix + strconv.Itoa(len(c.captures)-1)
+}
+
+// Process
+func (p *grokProcessor) Process(event *Event) ([]*Event, error) {
+	content := event.Content
+	if p.field != "" {
+		val, ok := event.Fields[p.field].(string)
+		if !ok {
+			return nil, ErrorGrokNoMatch
+		}
+		content = []byte(val)
+	}
+	for _, pattern := range p.patterns {
+		loc := pattern.reg.FindSubmatchIndex(content)
+		if loc == nil {
+			continue
+		}
+		fields := make(map[string]interface{}, len(pattern.captures))
+		for _, capture := range pattern.captures {
+			start, end := loc[2*capture.index], loc[2*capture.index+1]
+			if start < 0 {
+				continue
+			}
+			val, err := convertGrokValue(string(content[start:end]), capture.typ)
+			if err != nil {
+				return nil, err
+			}
+			fields[capture.name] = val
+		}
+		if p.target == "" {
+			for k, v := range fields {
+				event.PutField(k, v)
+			}
+		} else {
+			event.PutField(p.target, fields)
+		}
+		return []*Event{event}, nil
+	}
+	return nil, ErrorGrokNoMatch
+}
+
+// convertGrokValue 按照 %{SYNTAX:SEMANTIC:TYPE} 中的 TYPE 转换字段的类型
+func convertGrokValue(val, typ string) (interface{}, error) {
+	switch typ {
+	case "int":
+		return strconv.ParseInt(val, 10, 64)
+	case "float":
+		return strconv.ParseFloat(val, 64)
+	default:
+		return val, nil
+	}
+}
@@ -0,0 +1,138 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGrokProcessor(t *testing.T) {
+	patternFile := filepath.Join(t.TempDir(), "patterns")
+	content := "# custom patterns\nREQID [a-f0-9]{8}\nAPPLOG \\[%{REQID:req_id}\\] %{LOGLEVEL:level} %{GREEDYDATA:msg}\n"
+	if err := ioutil.WriteFile(patternFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		line    string
+		expect  map[string]interface{}
+	}{
+		{
+			name:    "combined apache log",
+			options: map[string]interface{}{"patterns": []string{"%{COMBINEDAPACHELOG}"}},
+			line:    `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`,
+			expect: map[string]interface{}{
+				"clientip": "127.0.0.1", "ident": "-", "auth": "frank", "timestamp": "10/Oct/2000:13:55:36 -0700",
+				"verb": "GET", "request": "/apache_pb.gif", "httpversion": "1.0", "response": "200", "bytes": "2326",
+				"referrer": `"http://www.example.com/start.html"`, "agent": `"Mozilla/4.08"`,
+			},
+		},
+		{
+			name:    "syslog line",
+			options: map[string]interface{}{"patterns": "%{SYSLOGLINE}"},
+			line:    "Mar  7 04:02:16 host-1 sshd[1234]: Accepted publickey for root",
+			expect: map[string]interface{}{
+				"timestamp": "Mar  7 04:02:16", "logsource": "host-1", "program": "sshd", "pid": "1234",
+				"message": "Accepted publickey for root",
+			},
+		},
+		{
+			name: "types and target",
+			options: map[string]interface{}{
+				"patterns": []interface{}{`^%{IP:client} %{NUMBER:status:int} %{NUMBER:latency:float}`},
+				"target":   "nginx",
+			},
+			line: "2001:db8::1 502 0.25",
+			expect: map[string]interface{}{
+				"nginx": map[string]interface{}{"client": "2001:db8::1", "status": int64(502), "latency": 0.25},
+			},
+		},
+		{
+			name: "patterns tried in order with custom definitions",
+			options: map[string]interface{}{
+				"patterns":            []string{"^%{APPLOG}$", `^%{TRACE:trace} (?<msg>.*)$`},
+				"pattern_files":       []string{patternFile},
+				"pattern_definitions": map[string]interface{}{"TRACE": `trace-%{INT}`},
+			},
+			line:   "trace-42 done",
+			expect: map[string]interface{}{"trace": "trace-42", "msg": "done"},
+		},
+		{
+			name: "pattern file",
+			options: map[string]interface{}{
+				"patterns":      []string{"^%{APPLOG}$"},
+				"pattern_files": []string{patternFile},
+			},
+			line:   "[0a1b2c3d] WARN disk full",
+			expect: map[string]interface{}{"req_id": "0a1b2c3d", "level": "WARN", "msg": "disk full"},
+		},
+	}
+	for _, tt := range tests {
+		processor, err := newGrokProcessor(tt.options)
+		if err != nil {
+			t.Fatalf("%s : %v", tt.name, err)
+		}
+		event := &Event{Content: []byte(tt.line)}
+		if _, err := processor.Process(event); err != nil {
+			t.Fatalf("%s : %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(event.Fields, tt.expect) {
+			t.Fatalf("%s expect=%v, actual=%v", tt.name, tt.expect, event.Fields)
+		}
+	}
+
+	processor, err := newGrokProcessor(map[string]interface{}{"patterns": []string{"^%{INT:code}$"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := processor.Process(&Event{Content: []byte("abc")}); !errors.Is(err, ErrorGrokNoMatch) {
+		t.Fatalf("expect ErrorGrokNoMatch, actual=%v", err)
+	}
+	for _, pattern := range []string{"%{UNKNOWN}", "%{INT:code:bool}"} {
+		if _, err := newGrokProcessor(map[string]interface{}{"patterns": []string{pattern}}); !errors.Is(err, ErrorGrokPattern) {
+			t.Fatalf("%s expect ErrorGrokPattern, actual=%v", pattern, err)
+		}
+	}
+	// 循环引用
+	if _, err := newGrokProcessor(map[string]interface{}{
+		"patterns":            []string{"%{LOOP}"},
+		"pattern_definitions": map[string]interface{}{"LOOP": "a%{LOOP}"},
+	}); !errors.Is(err, ErrorGrokPattern) {
+		t.Fatalf("expect ErrorGrokPattern, actual=%v", err)
+	}
+}
+
+func TestGrokPatterns(t *testing.T) {
+	// 内置的规则都可以被编译
+	for name := range grokPatterns {
+		if _, err := compileGrok("%{"+name+"}", grokPatterns); err != nil {
+			t.Fatalf("compile %s fail : %v", name, err)
+		}
+	}
+}
@@ -24,14 +24,10 @@ package filebeat
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"io"
-	"io/fs"
 	"io/ioutil"
 	"os"
-	"path/filepath"
-	"regexp"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -44,13 +40,73 @@ var (
 	EmptyWaitFiles error = errors.New("empty wait files")
 )
 
+const (
+	// minRetryBackoff Sink 处理失败后第一次重试的等待时间
+	minRetryBackoff = 100 * time.Millisecond
+	// maxRetryBackoff Sink 处理失败后重试的最大等待时间
+	maxRetryBackoff = 5 * time.Second
+	// defaultShutdownTimeout Close 时等待数据投递完成的默认时间
+	defaultShutdownTimeout = 5 * time.Second
+)
+
 // Config easy-filebeat 的配置信息
 type Config struct {
 	// Path 监听的文件路径
 	// 支持完全匹配以及正则匹配(监听对应规则的文件)
 	Path string
+	// Paths 监听的文件路径列表，支持 shell glob 通配符，以及使用 ** 匹配任意层级的目录
+	// 例如 /var/log/*/app-*.log、/data/logs/**/*.log，可以和 Path 同时使用
+	Paths []string
+	// Exclude 需要排除的文件路径列表，规则与 Paths 相同，按照完整路径进行匹配
+	Exclude []string
+	// FileIdentity 判断是否为同一个文件的方式，inode 或者 fingerprint，为空时默认为 inode
+	// I-Node 在文件删除后可能被复用，在部分 overlay、NFS 挂载下也可能发生变化，此时可以使用 fingerprint
+	FileIdentity string
+	// Fingerprint FileIdentity 为 fingerprint 时参与计算指纹的内容范围
+	Fingerprint FingerprintConfig
 	// MetaPath 元数据保存的位置
 	MetaPath string
+	// Start 首次发现的文件（Registry 中没有记录）从哪里开始采集，默认从文件头开始
+	Start StartConfig
+	// Checkpoint 采集位点的落盘策略
+	Checkpoint CheckpointConfig
+	// Batch 批量投递给 Sink 的配置
+	Batch BatchConfig
+	// LineReader 按行读取文件的配置，例如一行数据的最大长度以及默认的字符编码
+	LineReader LineReaderConfig
+	// Encodings 按照文件路径指定字符编码，按照顺序匹配第一个满足的规则，都不满足时使用 LineReader.Encoding
+	Encodings []PathEncoding
+	// Decompress 是否解压读取 .gz 等压缩文件（需要被 Path、Paths 匹配），支持的后缀见 RegisterDecompressor
+	// 压缩文件被视为不会再发生变化的文件，只会被完整读取一次，之后在 Registry 中标记为采集完成
+	// 压缩文件总是按照解压后内容的指纹识别，轮转后被压缩的文件从原文件已经采集到的位点继续采集
+	Decompress bool
+	// Container 解析 docker json-file 以及 CRI 格式的容器日志，在 JSON 解析以及多行合并之前执行，为 nil 时不进行解析
+	Container *ContainerConfig
+	// JSON 将每一行数据解析为结构化字段的配置，在多行合并之前执行，为 nil 时不进行解析
+	JSON *JSONConfig
+	// Multiline 多行合并的配置，为 nil 时不进行多行合并
+	Multiline *MultilineConfig
+	// IncludeLines 只投递至少匹配其中一个正则表达式的行，为空时不限制
+	// 按照 LineReader 读取到的每一行判断（容器日志为解析之后的每一行），在 JSON 解析以及多行合并之前执行，被丢弃的行位点依然会前进
+	IncludeLines []string
+	// ExcludeLines 丢弃匹配其中任意一个正则表达式的数据，在 IncludeLines 之后判断
+	ExcludeLines []string
+	// Processors 投递给 Sink 之前按照顺序执行的 Processor，可以修改、丢弃或者拆分事件
+	Processors []ProcessorConfig
+	// WatchMode 感知文件变化的方式，auto、inotify 或者 poll，为空时默认为 auto
+	WatchMode string
+	// IgnoreOlder 修改时间早于该时长的文件不会被采集，<= 0 表示不限制
+	// Registry 中没有记录的文件会记录为已经读到文件末尾，之后有新的数据写入时只采集新写入的数据
+	IgnoreOlder time.Duration
+	// Close 采集中的文件句柄的关闭策略
+	Close CloseConfig
+	// Clean Registry 中过期记录的清理策略，避免已经删除很久的文件的记录一直保留在 MetaPath 中
+	Clean CleanConfig
+	// MaxOpenFiles 同时打开采集的文件数量上限，<= 0 表示不做限制
+	// 超过上限的文件会进入等待队列，直到有文件采集结束后再开始采集
+	MaxOpenFiles int
+	// ShutdownTimeout Close 时等待已经读取的数据投递完成并被确认的最长时间，<= 0 时使用默认值 5s
+	ShutdownTimeout time.Duration
 	// Logger 日志输出
 	Logger *logrus.Logger
 }
@@ -62,49 +118,181 @@ type Harvester interface {
 	Init() error
 	// RegisterSink 注册一个处理文件的 Sink 处理者
 	RegisterSink(sink Sink)
+	// RegisterAckSink 注册一个支持确认机制的 Sink 处理者
+	RegisterAckSink(sink AckSink)
+	// RegisterBatchSink 注册一个批量处理数据的 Sink 处理者
+	RegisterBatchSink(sink BatchSink)
 	// Run 执行监听逻辑
 	Run(ctx context.Context)
+	// Stop 停止发现新的文件以及读取新的数据，等待已经读取的数据投递完成并被确认、最后一次位点落盘
+	// 以及所有的协程退出后返回，ctx 结束时放弃等待投递，直接落盘并返回 ctx 的错误
+	Stop(ctx context.Context) error
+	// Stats 获取运行过程中的统计信息
+	Stats() Stats
 	// OnError 出现异常时的回掉
 	OnError(err error)
 }
 
 // NewHarvester 创建一个 Harvester 实例
 func NewHarvester(cfg Config) (Harvester, error) {
+	cfg.Batch = cfg.Batch.withDefaults()
+	if cfg.WatchMode == "" {
+		cfg.WatchMode = WatchAuto
+	}
+	if cfg.WatchMode != WatchAuto && cfg.WatchMode != WatchInotify && cfg.WatchMode != WatchPoll {
+		return nil, ErrorWatchMode
+	}
+	if cfg.FileIdentity == "" {
+		cfg.FileIdentity = IdentityInode
+	}
+	if cfg.FileIdentity != IdentityInode && cfg.FileIdentity != IdentityFingerprint {
+		return nil, ErrorFileIdentity
+	}
+	if err := cfg.Fingerprint.validate(); err != nil {
+		return nil, err
+	}
+	cfg.Fingerprint = cfg.Fingerprint.withDefaults()
+	if err := cfg.Start.validate(); err != nil {
+		return nil, err
+	}
+	cfg.Start = cfg.Start.withDefaults()
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	// 解析文件匹配规则，准备用于判断感兴趣的文件列表
+	matcher, err := newFileMatcher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.LineReader.validate(); err != nil {
+		return nil, err
+	}
+	encodingRules, err := newEncodingRules(cfg.Encodings)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newLineFilter(cfg.IncludeLines, cfg.ExcludeLines)
+	if err != nil {
+		return nil, err
+	}
+	processors, err := newProcessorChain(cfg.Processors)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Container != nil {
+		if err := cfg.Container.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Multiline != nil {
+		if err := cfg.Multiline.validate(); err != nil {
+			return nil, err
+		}
+	}
 	beater := &harvester{
 		cfg:           cfg,
-		meta:          Metadata{},
-		waitDealFiles: make([]os.FileInfo, 0),
+		registry:      NewRegistry(),
+		workers:       make(map[string]*fileWorker),
+		waitDealFiles: make([]fileInfo, 0),
+		inactive:      make(map[string]fileMark),
 		logger:        cfg.Logger,
-		haveFileCh:    make(chan struct{}, 1),
+		matcher:       matcher,
+		encodingRules: encodingRules,
+		filter:        filter,
+		processors:    processors,
+		watchDirs:     make(map[string]struct{}),
+		msgCh:         make(chan message, 64),
+		rescanCh:      make(chan struct{}, 1),
+		scanInterval:  pollScanInterval,
+		readInterval:  pollReadInterval,
 	}
 
-	beater.haveFileCond = sync.NewCond(&beater.lock)
-
 	if err := beater.Init(); err != nil {
 		return nil, err
 	}
+	beater.checkpoint = newCheckpointer(cfg.MetaPath, cfg.Checkpoint, beater.registry, beater.OnError)
 
 	return beater, nil
 }
 
+// fileWorker 负责单个文件的采集
+type fileWorker struct {
+	// key 文件在 Registry 中的 key
+	key string
+	// source 文件路径
+	source string
+	// state 文件的 I-Node 信息
+	state StateOS
+	// notify 文件发生变化时唤醒采集协程
+	notify chan struct{}
+	// gone 文件已经被删除或者被重命名，但是按照 CloseConfig 继续读取
+	gone bool
+	// added 最后一次加入 ackTracker 等待提交的位点，只在采集协程中使用
+	added int64
+}
+
+// message 从文件中读取到的一条数据
+type message struct {
+	event *Event
+	ack   *pendingAck
+}
+
 // harvester
 type harvester struct {
 	lock  sync.RWMutex
 	sLock sync.RWMutex
 
-	cfg       Config
-	curReader atomic.Value
-	meta      Metadata
-	sinks     []Sink
+	cfg        Config
+	registry   *Registry
+	checkpoint *checkpointer
+	sinks      []BatchSink
 
-	waitDealFiles []os.FileInfo
+	// workers 正在采集中的文件，key 为文件在 Registry 中的 key
+	workers map[string]*fileWorker
+	// waitDealFiles 等待采集的文件列表，按照修改时间升序排列
+	waitDealFiles []fileInfo
+	// inactive 因为长时间没有新的数据而被关闭的文件，文件发生变化后才会重新开始采集
+	inactive map[string]fileMark
+	// stats 统计信息，通过 atomic 进行读写
+	stats Stats
 
 	logger *logrus.Logger
 
-	parentDir string
-
-	haveFileCond *sync.Cond
-	haveFileCh   chan struct{}
+	// matcher 用于查找感兴趣的文件
+	matcher *fileMatcher
+	// encodingRules 按照文件路径指定的字符编码
+	encodingRules []encodingRule
+	// filter 按照 IncludeLines、ExcludeLines 过滤数据
+	filter *lineFilter
+	// processors 投递给 Sink 之前执行的 Processor 处理链
+	processors processorChain
+	// watchDirs 已经在监听的目录
+	watchDirs map[string]struct{}
+
+	// watcher 为 nil 时表示使用轮询的方式感知文件变化
+	watcher watcher
+	// rescanCh 通知重新扫描待处理文件列表
+	rescanCh chan struct{}
+	// scanInterval 定时扫描待处理文件列表的间隔
+	scanInterval time.Duration
+	// readInterval 定时读取文件新数据的间隔
+	readInterval time.Duration
+	// scanned 是否已经完成了启动后的第一次扫描，只在扫描协程中使用
+	scanned bool
+
+	msgCh chan message
+
+	// runCtx 投递数据以及位点落盘使用的 ctx，Stop 时在数据投递完成之后才会被取消
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+	// cancelRead 停止发现新的文件以及读取新的数据
+	cancelRead context.CancelFunc
+	// dispatched dispatch 协程退出时关闭，Sink 一直阻塞时 Stop 不会无限等待该协程
+	dispatched chan struct{}
+	// wg 等待 Run 开启的除 dispatch 以外的协程退出
+	wg sync.WaitGroup
+	// workerWg 等待所有的采集协程退出
+	workerWg sync.WaitGroup
 }
 
 // Init
@@ -119,114 +307,481 @@ func (beater *harvester) Init() error {
 		if _, err := os.Create(metaPath); err != nil {
 			return err
 		}
-	} else {
-		if !json.Valid(data) {
-			return nil
-		}
-		// 读取上次工作的元数据文件信息
-		if err := json.Unmarshal(data, &beater.meta); err != nil {
-			return err
-		}
+		return nil
 	}
-	// 根据 metadat 初始化 Reader
-	if err := beater.initReaderFromMetadata(); err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return err
-		}
-
-		// 如果根据 metadata 里面记录的元数据找不到之前处理过的文件，那就认为是重新开始吧
-		beater.meta = Metadata{}
-		return beater.initReaderFromMetadata()
+	// 读取上次工作的元数据文件信息，恢复每个文件的采集状态
+	// 元数据文件通过原子替换的方式写入，如果内容不合法，说明文件被外部破坏，此时不能当作重新开始处理
+	registry, err := LoadRegistry(data)
+	if err != nil {
+		return err
 	}
+	beater.registry = registry
 	return nil
 }
 
 // Run 执行监听逻辑
 func (beater *harvester) Run(ctx context.Context) {
+	runCtx, cancelRun := context.WithCancel(ctx)
+	// 停止读取时投递以及落盘依然可以继续进行
+	ctx, cancelRead := context.WithCancel(runCtx)
+
+	beater.lock.Lock()
+	beater.runCtx = runCtx
+	beater.cancelRun = cancelRun
+	beater.cancelRead = cancelRead
+	beater.dispatched = make(chan struct{})
+	beater.initWatcher()
+	beater.lock.Unlock()
+
+	beater.wg.Add(2)
+	// 将各个文件读取到的数据统一投递给 Sink
+	go func(dispatched chan struct{}) {
+		defer close(dispatched)
+		beater.dispatch(runCtx)
+	}(beater.dispatched)
+	// 按照落盘策略持久化采集位点
+	go func() {
+		defer beater.wg.Done()
+		beater.checkpoint.run(runCtx)
+	}()
+	// 根据文件事件通知驱动文件的发现以及读取
+	if beater.watcher != nil {
+		beater.wg.Add(1)
+		go func() {
+			defer beater.wg.Done()
+			beater.watch(ctx)
+		}()
+	}
 
 	// 开启定时刷新待处理文件列表信息
 	go func(ctx context.Context) {
+		defer beater.wg.Done()
+
 		// 设置待处理文件列表信息数据
-		if err := beater.setWaitDealFiles(); err != nil {
+		if err := beater.setWaitDealFiles(ctx); err != nil {
 			beater.OnError(err)
 		}
 
-		ticker := time.NewTicker(time.Duration(5 * time.Second))
+		ticker := time.NewTicker(beater.scanInterval)
 		for {
 			select {
 			case <-ticker.C:
-				if err := beater.setWaitDealFiles(); err != nil {
-					beater.logger.Errorf("set wait deail files fail : %+v", err)
-				}
+			case <-beater.rescanCh:
 			case <-ctx.Done():
 				ticker.Stop()
 				return
 			}
+			if err := beater.setWaitDealFiles(ctx); err != nil {
+				beater.logger.Errorf("set wait deail files fail : %+v", err)
+			}
 		}
 	}(ctx)
+}
 
-	//
-	go func(ctx context.Context) {
-		for {
-			// 元数据没有任何信息
-			if beater.curReader.Load() == nil {
-				if err := beater.initReaderFromWait(); err != nil {
-					// if errors.Is(err, os.ErrNotExist) {
-					// 	continue
-					// }
-					beater.OnError(err)
-					time.Sleep(100 * time.Millisecond)
-				} else {
-					break
-				}
+// initWatcher 根据 WatchMode 初始化文件事件通知，初始化失败时退化为轮询的方式
+//
+//	@receiver beater
+func (beater *harvester) initWatcher() {
+	if beater.cfg.WatchMode == WatchPoll {
+		return
+	}
+	w, err := newWatcher()
+	if err != nil {
+		if beater.cfg.WatchMode == WatchInotify || !errors.Is(err, ErrorWatchUnsupported) {
+			beater.logger.Errorf("init file watcher fail, fallback to poll : %+v", err)
+		}
+		return
+	}
+	beater.watcher = w
+	beater.scanInterval = watchScanInterval
+	beater.readInterval = watchReadInterval
+}
+
+// watch 处理文件变化的事件：目录下文件的增删触发重新扫描，文件的写入唤醒对应的采集协程
+//
+//	@receiver beater
+//	@param ctx
+func (beater *harvester) watch(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		beater.watcher.Close()
+	}()
+
+	for event := range beater.watcher.Events() {
+		// 忽略不感兴趣的文件，例如 MetaPath 落盘时产生的临时文件
+		if !beater.interested(event) {
+			continue
+		}
+		switch event.Op {
+		case watchCreate:
+			beater.rescan()
+		case watchRemove:
+			beater.rescan()
+			beater.wakeWorkers(event.Path)
+		case watchWrite:
+			// fingerprint 模式下文件写入足够的数据、压缩文件写入完成之后才能被采集
+			// 因为长时间没有新的数据而被关闭的文件需要重新开始采集
+			if woken := beater.wakeWorkers(event.Path); !woken && (beater.cfg.FileIdentity == IdentityFingerprint || beater.cfg.Decompress || beater.cfg.Close.Inactive > 0) {
+				beater.rescan()
 			}
+		case watchOverflow:
+			// 有事件被丢弃了，重新扫描并唤醒所有的采集协程
+			beater.rescan()
+			beater.wakeWorkers("")
 		}
+	}
+	// 等待 watcher 的资源释放完成
+	_ = beater.watcher.Close()
+}
 
-		ticker := time.NewTicker(time.Duration(50 * time.Millisecond))
+// interested 判断文件变化的事件是否需要处理
+//
+//	@receiver beater
+//	@param event
+//	@return bool
+func (beater *harvester) interested(event watchEvent) bool {
+	if event.Path == "" || beater.matcher.match(event.Path) {
+		return true
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				ticker.Stop()
-				return
-			case <-ticker.C:
-				beater.innerRun()
+	beater.lock.RLock()
+	_, watched := beater.watchDirs[event.Path]
+	beater.lock.RUnlock()
+	if watched {
+		return true
+	}
+	// 新创建的子目录下可能存在需要采集的文件
+	if event.Op == watchCreate {
+		if info, err := os.Stat(event.Path); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// rescan 通知重新扫描待处理文件列表
+func (beater *harvester) rescan() {
+	select {
+	case beater.rescanCh <- struct{}{}:
+	default:
+	}
+}
+
+// wakeWorkers 唤醒采集指定文件的协程，path 为空时唤醒所有的采集协程
+//
+//	@receiver beater
+//	@param path
+//	@return bool 是否有采集协程被唤醒
+func (beater *harvester) wakeWorkers(path string) bool {
+	beater.lock.RLock()
+	defer beater.lock.RUnlock()
+
+	woken := false
+	for _, worker := range beater.workers {
+		if path != "" && worker.source != path {
+			continue
+		}
+		woken = true
+		select {
+		case worker.notify <- struct{}{}:
+		default:
+		}
+	}
+	return woken
+}
+
+// runWorker 采集单个文件，每个文件拥有独立的 Reader 以及位点信息
+//
+//	@receiver beater
+//	@param ctx
+//	@param worker
+//	@param offset
+func (beater *harvester) runWorker(ctx context.Context, worker *fileWorker, offset int64) {
+	defer beater.workerWg.Done()
+	defer beater.onWorkerExit(ctx, worker)
+
+	reader, err := beater.newReader(worker.source, &offset)
+	if err != nil {
+		beater.OnError(err)
+		return
+	}
+	defer reader.Close()
+	worker.added = reader.Offset()
+
+	tracker := newAckTracker(func(offset int64, lines int) {
+		beater.reportAndSyncMetadata(worker, offset, lines)
+	})
+
+	ticker := time.NewTicker(beater.readInterval)
+	defer ticker.Stop()
+
+	lastActive := time.Now()
+	for {
+		// 读取之前记录文件的状态，之后的写入一定会使文件的状态发生变化
+		var mark fileMark
+		if info, err := reader.CurFile().Stat(); err == nil {
+			mark = fileMark{modTime: info.ModTime(), size: info.Size()}
+		}
+		prev := reader.Offset()
+
+		finished := beater.innerRun(ctx, worker, reader, tracker)
+		if ctx.Err() != nil {
+			// 停止读取了，等待已经投递的数据被确认，使最后一次落盘的位点尽可能新
+			_ = tracker.wait(beater.runCtx)
+			return
+		}
+		if finished {
+			// 等待已经投递的数据全部被确认后，才标记文件采集完成
+			if err := tracker.wait(beater.runCtx); err == nil {
+				beater.markFinished(worker.key)
 			}
+			return
 		}
-	}(ctx)
+		if reader.Offset() != prev {
+			lastActive = time.Now()
+		} else if inactive := beater.cfg.Close.Inactive; inactive > 0 && time.Since(lastActive) >= inactive {
+			// 长时间没有新的数据，关闭文件句柄，文件发生变化后再重新打开
+			if err := tracker.wait(beater.runCtx); err == nil {
+				if worker.gone {
+					beater.markFinished(worker.key)
+				} else {
+					beater.closeInactive(worker, mark)
+				}
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		case <-worker.notify:
+		}
+	}
+}
+
+// newReader 根据配置构造读取文件的 Reader
+//
+//	@receiver beater
+//	@param source
+//	@param offset
+//	@return Reader
+//	@return error
+func (beater *harvester) newReader(source string, offset *int64) (Reader, error) {
+	reader, err := beater.newLineReader(source, offset)
+	if err != nil {
+		return nil, err
+	}
+	if beater.cfg.Container != nil {
+		cr, err := NewContainerReader(reader, *beater.cfg.Container)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		reader = cr
+	}
+	if !beater.filter.empty() {
+		reader = newFilterReader(reader, beater.filter, func() {
+			atomic.AddInt64(&beater.stats.DroppedLines, 1)
+		})
+	}
+	if beater.cfg.JSON != nil {
+		reader = NewJSONReader(reader, *beater.cfg.JSON)
+	}
+	if beater.cfg.Multiline != nil {
+		ml, err := NewMultilineReader(reader, *beater.cfg.Multiline)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		reader = ml
+	}
+	return reader, nil
+}
+
+// newLineReader 根据文件类型构造按行读取的 Reader
+//
+//	@receiver beater
+//	@param source
+//	@param offset
+//	@return Reader
+//	@return error
+func (beater *harvester) newLineReader(source string, offset *int64) (Reader, error) {
+	cfg := beater.cfg.LineReader
+	cfg.Encoding = beater.encodingFor(source)
+	if beater.isCompressed(source) {
+		return NewCompressedLineReader(source, offset, cfg)
+	}
+	// fingerprint 模式下文件被截断后又迅速写满时，需要通过文件头部的内容才能发现
+	if beater.cfg.FileIdentity == IdentityFingerprint && cfg.FingerprintBytes == 0 {
+		cfg.FingerprintBytes = int(beater.cfg.Fingerprint.Offset) + beater.cfg.Fingerprint.Length
+	}
+	return NewLineReaderWithConfig(source, offset, cfg)
 }
 
-func (beater *harvester) innerRun() {
+// innerRun 读取文件直到没有新的数据或者停止读取，返回当前文件是否已经采集结束
+func (beater *harvester) innerRun(ctx context.Context, worker *fileWorker, reader Reader, tracker *ackTracker) bool {
 	for {
-		curReader := beater.curReader.Load().(Reader)
-		msg, err := curReader.Next()
+		if ctx.Err() != nil {
+			return false
+		}
+		event, err := reader.Next()
 		if err != nil {
 			switch err {
+			case ErrorFinished:
+				// 压缩文件已经全部读取完成
+				return true
 			case ErrorRemoved, ErrorRename:
-				// 切换文件，转到下一个要处理的
-				beater.switchNextFile()
+				// 当前文件已经被切走了，结束当前文件的采集
+				if (err == ErrorRemoved && beater.cfg.Close.closeOnRemoved()) ||
+					(err == ErrorRename && beater.cfg.Close.closeOnRenamed()) {
+					return true
+				}
+				// 继续读取被切走的文件，直到满足 CloseConfig.Inactive 的条件
+				worker.gone = true
+				return false
+			case ErrorTruncated:
+				if beater.cfg.FileIdentity == IdentityFingerprint {
+					// 文件的指纹已经发生了变化，作为一个新的文件重新采集
+					beater.rescan()
+					return true
+				}
+				// 文件被截断了，Reader 已经从文件头开始重新读取，等待截断前投递的数据全部被确认后再重置位点
+				// 避免截断前的位点在重置之后被提交
+				beater.logger.Warnf("harvester file truncated, read from the beginning : %s", worker.source)
+				if err := tracker.wait(beater.runCtx); err != nil {
+					return false
+				}
+				beater.reportAndSyncMetadata(worker, 0, 0)
+				worker.added = 0
+				continue
 			case io.EOF:
-				// 读完当前文件了，重新再 Open 一次，有可能还能在继续读
+				// 被过滤的行不会返回，读到文件末尾时按照顺序提交已经读取完的位点
+				if offset := reader.Offset(); offset > worker.added {
+					worker.added = offset
+					tracker.add(offset).expect(0)
+				}
 				// 当前日志文件还没触发切换，也没有新的数据可供读取，因此进入重试等待
-				return
+				return false
 			case os.ErrNotExist:
 				// 不存在文件
 				fallthrough
 			default:
 				beater.OnError(err)
-				return
+				return false
 			}
-		} else {
-			beater.sLock.RLock()
-			for i := range beater.sinks {
-				beater.sinks[i].OnMessage(msg)
-			}
-			beater.sLock.RUnlock()
+		}
 
-			// 上报当前的metadat数据并持久化
-			beater.reportAndSyncMetadata()
+		start, end := event.Offset, event.EndOffset
+		if end < start {
+			// ContainerReader 会将结束位点限制在其他输出流尚未结束的分段数据之前
+			start = end
+		}
+		events := beater.processors.run(event, beater.OnError)
+		if end > worker.added {
+			worker.added = end
+		}
+		if len(events) == 0 {
+			// 被丢弃的数据不需要投递，但是位点依然需要按照顺序提交
+			tracker.add(end).expect(0)
 			continue
 		}
+		for i := range events {
+			// 拆分出的多条数据全部被确认之后才能提交该行的结束位点
+			offset := start
+			if i == len(events)-1 {
+				offset = end
+			}
+			// 已经读取到的数据即使在停止读取之后也需要投递出去，否则会一直等待该数据被确认
+			select {
+			case beater.msgCh <- message{event: events[i], ack: tracker.add(offset)}:
+			case <-beater.runCtx.Done():
+				return false
+			}
+		}
+	}
+}
+
+// dispatch 将读取到的数据攒批之后投递给所有的 Sink，数据被所有 Sink 确认后才会提交对应文件的位点信息
+//
+//	@receiver beater
+//	@param ctx
+func (beater *harvester) dispatch(ctx context.Context) {
+	var (
+		pending = &batch{}
+		lingerC <-chan time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-beater.msgCh:
+			if len(pending.msgs) == 0 {
+				lingerC = time.After(beater.cfg.Batch.MaxLinger)
+			}
+			if full := pending.add(msg, beater.cfg.Batch); full {
+				lingerC = nil
+				beater.publish(ctx, pending.take())
+			}
+		case <-lingerC:
+			lingerC = nil
+			beater.publish(ctx, pending.take())
+		}
+	}
+}
+
+// publish 将一批数据投递给所有的 Sink
+//
+//	@receiver beater
+//	@param ctx
+//	@param msgs
+func (beater *harvester) publish(ctx context.Context, msgs []message) {
+	beater.sLock.RLock()
+	sinks := beater.sinks
+	beater.sLock.RUnlock()
+
+	events := make([]*Event, 0, len(msgs))
+	for i := range msgs {
+		msgs[i].ack.expect(len(sinks))
+		events = append(events, msgs[i].event)
+	}
+	for i := range sinks {
+		beater.deliver(ctx, sinks[i], events, msgs)
+	}
+}
+
+// deliver 将一批数据投递给 Sink，投递失败时按照退避策略进行重试，直到成功或者 ctx 结束
+//
+//	@receiver beater
+//	@param ctx
+//	@param sink
+//	@param events
+//	@param msgs
+func (beater *harvester) deliver(ctx context.Context, sink BatchSink, events []*Event, msgs []message) {
+	var once sync.Once
+	ack := func() {
+		once.Do(func() {
+			for i := range msgs {
+				msgs[i].ack.done()
+			}
+		})
+	}
+
+	backoff := minRetryBackoff
+	for {
+		err := sink.OnBatch(events, ack)
+		if err == nil {
+			return
+		}
+		beater.OnError(err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
 	}
 }
 
@@ -239,207 +794,380 @@ func (beater *harvester) OnError(err error) {
 //	@receiver beater
 //	@param sink
 func (beater *harvester) RegisterSink(sink Sink) {
+	beater.RegisterBatchSink(AdaptSink(sink))
+}
+
+// RegisterAckSink 注册一个支持确认机制的 Sink，只有被确认的数据其位点才会被持久化
+//
+//	@receiver beater
+//	@param sink
+func (beater *harvester) RegisterAckSink(sink AckSink) {
+	beater.RegisterBatchSink(AdaptAckSink(sink))
+}
+
+// RegisterBatchSink 注册一个批量处理数据的 Sink，攒批的策略由 Config.Batch 决定
+//
+//	@receiver beater
+//	@param sink
+func (beater *harvester) RegisterBatchSink(sink BatchSink) {
 	beater.sLock.Lock()
 	defer beater.sLock.Unlock()
 
-	beater.sinks = append(beater.sinks, sink)
+	// 采用 copy-on-write 的方式，避免影响正在投递中的数据
+	sinks := make([]BatchSink, 0, len(beater.sinks)+1)
+	sinks = append(sinks, beater.sinks...)
+	beater.sinks = append(sinks, sink)
 }
 
-// Close
+// Close 按照 Config.ShutdownTimeout 等待数据投递完成后停止
 //
 //	@receiver beater
 //	@return error
 func (beater *harvester) Close() error {
-	return beater.curReader.Load().(Reader).Close()
-}
-
-// initReaderFromMetadata
-func (beater *harvester) initReaderFromMetadata() error {
-
-	if beater.meta.CurFile != "" {
-		curReader, err := NewLineReader(beater.meta.CurFile, &beater.meta.CurOffset)
-		if err != nil {
-			return err
-		}
-		beater.curReader.Store(curReader)
-	}
-
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), beater.cfg.ShutdownTimeout)
+	defer cancel()
 
+	return beater.Stop(ctx)
 }
 
-// initReaderFromWait
+// Stop 停止发现新的文件以及读取新的数据，等待已经读取的数据投递完成并被确认后，进行最后一次落盘
 //
 //	@receiver beater
-func (beater *harvester) initReaderFromWait() error {
-
+//	@param ctx
+//	@return error
+func (beater *harvester) Stop(ctx context.Context) error {
+	// 持有锁的情况下停止读取，保证之后不会再有新的采集协程被开启
 	beater.lock.Lock()
-	if len(beater.waitDealFiles) == 0 {
-		beater.haveFileCond.Wait()
+	cancelRead, cancelRun, dispatched := beater.cancelRead, beater.cancelRun, beater.dispatched
+	if cancelRead != nil {
+		cancelRead()
 	}
-
-	waitDeal := beater.waitDealFiles[len(beater.waitDealFiles)-1]
-
-	// 更新 metadata 数据信息
-	beater.meta.CurFile = waitDeal.Name()
-	beater.meta.CurOffset = 0
-	beater.meta.CurFileINode = GetOSState(waitDeal).String()
 	beater.lock.Unlock()
-
-	// 构造行读取 Reader
-	fileName := filepath.Join(beater.parentDir, waitDeal.Name())
-	curReader, err := NewLineReader(fileName, &beater.meta.CurOffset)
-	if err != nil {
-		return err
+	if cancelRead == nil {
+		// 还没有执行 Run
+		return nil
 	}
 
-	old := beater.curReader.Load()
-	if old != nil {
-		old.(Reader).Close()
+	// 各个采集协程会等待已经投递的数据被确认后才退出，Reader 也会在此时关闭
+	drained := make(chan struct{})
+	go func() {
+		beater.workerWg.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
 	}
 
-	beater.curReader.Store(curReader)
-	return nil
+	// 停止投递，checkpointer 退出前会进行最后一次落盘
+	cancelRun()
+	beater.workerWg.Wait()
+	beater.wg.Wait()
+	// Sink 可能一直阻塞在 OnBatch 中，ctx 结束后不再等待 dispatch 协程退出
+	select {
+	case <-dispatched:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
 }
 
-// setWaitDealFiles 更新待处理的文件列表
+// setWaitDealFiles 更新待处理的文件列表，并尝试开始采集
 //
 //	@receiver beater
+//	@param ctx
 //	@return error
-func (beater *harvester) setWaitDealFiles() error {
-	var (
-		result []os.FileInfo
-		err    error
-	)
-
-	for {
-		result, err = beater.loadCurFiles()
-		if err != nil {
-			return err
+func (beater *harvester) setWaitDealFiles(ctx context.Context) error {
+	result, dirs, err := beater.loadCurFiles()
+	if err != nil {
+		return err
+	}
+	// 目录可能在启动之后才被创建，每次扫描时都尝试进行监听
+	beater.watchDir(dirs)
+
+	// 记录本次扫描发现的文件信息
+	// LastSeen 的变化不需要立即落盘，跟随下一次落盘一起持久化即可
+	now := time.Now()
+	changed := false
+	ready := make([]fileInfo, 0, len(result))
+	seen := make(map[string]struct{}, len(result))
+	// 从旧版本 Metadata 迁移后的首次扫描，没有记录并且修改时间不晚于旧版本当前处理文件的文件视为已经处理完成
+	var legacyTime time.Time
+	if !beater.scanned {
+		legacyTime = beater.legacyModTime(result)
+	}
+	for i := range result {
+		item := result[i]
+		if !beater.identify(&item) {
+			continue
 		}
-
-		// 如果当前获取到的文件列表为空
-		result = beater.ignoreAlreadDeal(result)
-		if len(result) == 0 {
-			time.Sleep(time.Duration(200 * time.Millisecond))
-		} else {
-			break
+		state, tracked := beater.registry.Get(item.key)
+		older := beater.isOlder(item)
+		// 轮转后被压缩的文件从原文件已经采集到的位点继续采集，原文件还在采集中时等待其采集结束
+		var source *FileState
+		if !tracked && !older && beater.isCompressed(item.path) {
+			if key, fs, ok := beater.rotationSource(item); ok {
+				beater.lock.RLock()
+				_, harvesting := beater.workers[key]
+				beater.lock.RUnlock()
+				if harvesting {
+					continue
+				}
+				source = &fs
+			}
+		}
+		seen[item.key] = struct{}{}
+		// 已经采集完成的文件的 key 被新的文件复用了，作为一个新的文件从头开始采集
+		reused := false
+		if tracked && beater.isReused(item, state) {
+			beater.logger.Infof("harvester file key reused, read from the beginning : %s, previous source : %s", item.path, state.Source)
+			reused = true
+		}
+		if !older {
+			ready = append(ready, item)
 		}
+		// 启动时 Registry 中没有记录的文件，按照 Config.Start 决定开始采集的位点
+		// 被 IgnoreOlder 忽略的文件视为已经读到文件末尾
+		start, fresh, finished := int64(0), false, false
+		if !tracked && source != nil {
+			start, fresh = source.Offset, true
+		} else if !tracked && (older || !beater.scanned) {
+			processed := !legacyTime.IsZero() && !item.ModTime().After(legacyTime)
+			start, fresh = item.Size(), true
+			if beater.isCompressed(item.path) {
+				// 压缩文件的位点为解压后的字节数，不需要采集历史数据时直接标记为采集完成
+				start = 0
+				finished = older || processed || beater.cfg.Start.Position == StartEnd
+			}
+			if !older && !processed && !finished {
+				offset, err := beater.startOffset(item)
+				if err != nil {
+					beater.OnError(err)
+				}
+				start = offset
+			}
+		}
+		beater.registry.Update(item.key, func(fs *FileState) {
+			if fresh {
+				fs.Offset = start
+				fs.Finished = finished
+			}
+			if reused {
+				fs.Offset = 0
+				fs.Finished = false
+				changed = true
+			}
+			if fs.Source != item.path || fs.State != item.state || fs.Fingerprint != item.fingerprint {
+				fs.Source = item.path
+				fs.State = item.state
+				fs.Fingerprint = item.fingerprint
+				changed = true
+			}
+			fs.LastSeen = now
+		})
+	}
+	result = ready
+	beater.scanned = true
+	if changed {
+		beater.checkpoint.markDirty()
 	}
+	beater.cleanRegistry(seen)
 
 	// 更新待处理文件列表
 	func() {
 		beater.lock.Lock()
 		defer beater.lock.Unlock()
 
-		beater.waitDealFiles = result
+		beater.waitDealFiles = beater.ignoreAlreadDeal(result)
 	}()
 
-	// 广播通知有新的文件可以用了
-	beater.haveFileCond.Broadcast()
-
+	beater.scheduleWorkers(ctx)
 	return nil
 }
 
-// switchNextFile
+// scheduleWorkers 在不超过 MaxOpenFiles 的前提下，为等待中的文件开启采集协程
 //
 //	@receiver beater
-//	@return error
-func (beater *harvester) switchNextFile() error {
-	// 关闭之前的文件 Reader
-	old := beater.curReader.Load()
-	if old != nil {
-		old.(Reader).Close()
+//	@param ctx
+func (beater *harvester) scheduleWorkers(ctx context.Context) {
+	beater.lock.Lock()
+	defer beater.lock.Unlock()
+
+	if ctx.Err() != nil {
+		return
 	}
 
+	remain := make([]fileInfo, 0, len(beater.waitDealFiles))
+	for i := range beater.waitDealFiles {
+		item := beater.waitDealFiles[i]
+		key := item.key
+		if beater.isHarvesting(item) {
+			continue
+		}
+		if beater.cfg.MaxOpenFiles > 0 && len(beater.workers) >= beater.cfg.MaxOpenFiles {
+			remain = append(remain, item)
+			continue
+		}
+
+		state, _ := beater.registry.Get(key)
+		worker := &fileWorker{
+			key:    key,
+			source: item.path,
+			state:  item.state,
+			notify: make(chan struct{}, 1),
+		}
+		beater.workers[key] = worker
+		beater.workerWg.Add(1)
+		go beater.runWorker(ctx, worker, state.Offset)
+	}
+	beater.waitDealFiles = remain
+}
+
+// onWorkerExit 文件采集协程退出，释放占用的名额并让等待中的文件开始采集
+//
+//	@receiver beater
+//	@param ctx
+//	@param worker
+func (beater *harvester) onWorkerExit(ctx context.Context, worker *fileWorker) {
 	func() {
 		beater.lock.Lock()
 		defer beater.lock.Unlock()
 
-		// 第一步：根据 INode info 移除指定的 os.FileInfo
-		curStatStr := beater.meta.CurFileINode
-		pos := -1
-		for i := range beater.waitDealFiles {
-			if curStatStr == GetOSState(beater.waitDealFiles[i]).String() {
-				pos = i
-				break
-			}
-		}
-
-		if pos != -1 {
-			beater.waitDealFiles = append(beater.waitDealFiles[:pos], beater.waitDealFiles[pos+1:]...)
-		}
+		delete(beater.workers, worker.key)
 	}()
 
-	// 第二步：调用 initReaderFromWait 进行文件的切换动作
-	return beater.initReaderFromWait()
+	beater.scheduleWorkers(ctx)
+}
+
+// markFinished 标记文件已经采集完成，后续不再对该文件进行采集
+//
+//	@receiver beater
+//	@param key
+func (beater *harvester) markFinished(key string) {
+	beater.registry.Update(key, func(state *FileState) {
+		state.Finished = true
+	})
+	beater.checkpoint.markDirty()
 }
 
-// ignoreAlreadDeal
+// ignoreAlreadDeal 过滤掉已经采集完成以及正在采集中的文件
 //
 //	@receiver beater
 //	@param source
-//	@return []os.FileInfo
-func (beater *harvester) ignoreAlreadDeal(source []os.FileInfo) []os.FileInfo {
+//	@return []fileInfo
+func (beater *harvester) ignoreAlreadDeal(source []fileInfo) []fileInfo {
 
-	// 按照修改时间进行逆序排序
+	// 按照修改时间进行升序排序，优先处理更早的文件
 	sort.Slice(source, func(i, j int) bool {
-		return source[i].ModTime().After(source[j].ModTime())
+		return source[i].ModTime().Before(source[j].ModTime())
 	})
 
-	// 这里是逆序的结果
-
-	pos := len(source)
+	target := make([]fileInfo, 0, len(source))
 	for i := range source {
 		item := source[i]
-		curINodeInfo := GetOSState(item).String()
 
-		if beater.meta.CurFileINode == curINodeInfo {
-			pos = i
-			break
+		if state, ok := beater.registry.Get(item.key); ok && state.Finished {
+			continue
 		}
+		if beater.isHarvesting(item) {
+			continue
+		}
+		if mark, ok := beater.inactive[item.key]; ok {
+			if !mark.changed(item) {
+				continue
+			}
+			delete(beater.inactive, item.key)
+		}
+		target = append(target, item)
 	}
 
-	return source[:pos]
+	return target
 }
 
-// loadCurFiles 获取要监听的日志目录下的所有日志文件信息
+// isReused 判断已经采集完成的文件的 key 是否被新的文件复用了，例如文件被删除后 I-Node 被新的文件复用
+// 文件长度小于记录的位点，或者在其他路径下被发现并且在最后一次被发现之后又被修改过时，视为新的文件
+// 轮转时的重命名不会修改文件内容，因此不会被当作新的文件
 //
 //	@receiver beater
-//	@return []os.FileInfo
-//	@return error
-func (beater *harvester) loadCurFiles() ([]os.FileInfo, error) {
-	beater.parentDir = filepath.Dir(beater.cfg.Path)
-
-	fList, err := ioutil.ReadDir(beater.parentDir)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return []os.FileInfo{}, nil
-		}
-		return nil, err
+//	@param item
+//	@param state
+//	@return bool
+func (beater *harvester) isReused(item fileInfo, state FileState) bool {
+	// 压缩文件的位点为解压后的字节数，并且 fingerprint 按照文件内容识别，都不存在 key 被复用的问题
+	if !state.Finished || item.key != item.state.String() || beater.isCompressed(item.path) {
+		return false
+	}
+	if item.Size() < state.Offset {
+		return true
 	}
+	return state.Source != item.path && item.ModTime().After(state.LastSeen)
+}
 
-	// 正则编译，准备用于判断感兴趣的文件列表
-	regx, err := regexp.Compile(filepath.Base(beater.cfg.Path))
-	if err != nil {
-		return nil, err
+// isHarvesting 判断文件是否正在被采集，调用方需要持有 lock
+// fingerprint 模式下文件被截断后 key 会发生变化，需要同时按照 I-Node 信息判断，避免同一个文件被两个协程同时采集
+//
+//	@receiver beater
+//	@param item
+//	@return bool
+func (beater *harvester) isHarvesting(item fileInfo) bool {
+	if _, ok := beater.workers[item.key]; ok {
+		return true
+	}
+	if beater.cfg.FileIdentity != IdentityFingerprint {
+		return false
 	}
+	for _, worker := range beater.workers {
+		if worker.state == item.state {
+			return true
+		}
+	}
+	return false
+}
 
-	target := make([]os.FileInfo, 0)
+// loadCurFiles 获取所有匹配 Path、Paths 并且没有被 Exclude 排除的日志文件信息
+//
+//	@receiver beater
+//	@return []fileInfo
+//	@return []string
+//	@return error
+func (beater *harvester) loadCurFiles() ([]fileInfo, []string, error) {
+	return beater.matcher.scan()
+}
 
-	for i := range fList {
-		item := fList[i]
-		if regx.Match([]byte(item.Name())) {
-			target = append(target, item)
+// watchDir 监听扫描过的目录
+//
+//	@receiver beater
+//	@param dirs
+func (beater *harvester) watchDir(dirs []string) {
+	if beater.watcher == nil {
+		return
+	}
+	for i := range dirs {
+		if err := beater.watcher.Add(dirs[i]); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				beater.OnError(err)
+			}
+			continue
 		}
+		beater.lock.Lock()
+		beater.watchDirs[dirs[i]] = struct{}{}
+		beater.lock.Unlock()
 	}
-
-	return target, nil
 }
 
 // reportAndSyncMetadata 上报当前的数据处理情况
-func (beater *harvester) reportAndSyncMetadata() {
-	// TODO 这里目前是实时落盘，感觉这里可以用 mmap 的方式，加快写的速度，然后将落盘的时机转交操作系统完成
-	data, _ := json.Marshal(beater.meta)
-	ioutil.WriteFile(beater.cfg.MetaPath, data, fs.ModeAppend)
+// 文件路径只在扫描时重新发现文件之后更新，避免文件被重命名后记录的路径被改回采集协程打开时的路径
+func (beater *harvester) reportAndSyncMetadata(worker *fileWorker, offset int64, lines int) {
+	beater.registry.Update(worker.key, func(state *FileState) {
+		if state.Source == "" {
+			state.Source = worker.source
+		}
+		state.Offset = offset
+	})
+	beater.checkpoint.onAck(lines)
 }
@@ -24,38 +24,465 @@ package filebeat
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 func TestNewHarvester(t *testing.T) {
-	logger := logrus.New()
-	logger.SetOutput(os.Stdout)
-	harvester, err := NewHarvester(Config{
-		Path:     "/data/Github/polaris-server/log/operation/polaris-history.log",
-		MetaPath: "./test",
-		Logger:   logger,
+	dir := t.TempDir()
+	expect := make([]string, 0)
+	for _, name := range []string{"app.log", "access.log", "error.log"} {
+		lines := make([]string, 0)
+		for i := 0; i < 10; i++ {
+			lines = append(lines, fmt.Sprintf("%s_line=%d", name, i))
+		}
+		writeLines(t, filepath.Join(dir, name), lines...)
+		expect = append(expect, lines...)
+	}
+
+	sink := &mockSink{}
+	harvester := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, ".*\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
 	})
+	harvester.RegisterSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	harvester.Run(ctx)
+
+	sink.waitFor(t, len(expect))
+	actual := sink.messages()
+	sort.Strings(expect)
+	sort.Strings(actual)
+	for i := range expect {
+		if expect[i] != actual[i] {
+			t.Fatalf("no equal, expect=[%s], acutal=[%s]", expect[i], actual[i])
+		}
+	}
+}
+
+func TestHarvester_MaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a.log")
+	writeLines(t, first, "a_line=1")
+	// 保证 a.log 比 b.log 更早被修改，从而优先被采集
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(first, past, past); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, filepath.Join(dir, "b.log"), "b_line=1")
+
+	sink := &mockSink{}
+	harvester := newTestHarvester(t, Config{
+		Path:         filepath.Join(dir, ".*\\.log$"),
+		MetaPath:     filepath.Join(dir, "meta"),
+		MaxOpenFiles: 1,
+	})
+	harvester.RegisterSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	harvester.Run(ctx)
+
+	sink.waitFor(t, 1)
+	time.Sleep(200 * time.Millisecond)
+	if msgs := sink.messages(); len(msgs) != 1 || msgs[0] != "a_line=1" {
+		t.Fatalf("only a.log should be harvested, actual=%v", msgs)
+	}
 
+	// a.log 被删除后释放名额，b.log 开始被采集
+	if err := os.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	sink.waitFor(t, 2)
+	if msgs := sink.messages(); msgs[1] != "b_line=1" {
+		t.Fatalf("b.log should be harvested, actual=%v", msgs)
+	}
+}
+
+func TestHarvester_Resume(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		Path:     filepath.Join(dir, ".*\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+		Checkpoint: CheckpointConfig{
+			OnAck: true,
+		},
+	}
+	writeLines(t, filepath.Join(dir, "app.log"), "app_line=1")
+	writeLines(t, filepath.Join(dir, "access.log"), "access_line=1")
+
+	sink := &mockSink{}
+	harvester := newTestHarvester(t, cfg)
+	harvester.RegisterSink(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	harvester.Run(ctx)
+	sink.waitFor(t, 2)
+	// 等待位点信息落盘
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	_ = harvester.Close()
+
+	writeLines(t, filepath.Join(dir, "app.log"), "app_line=2")
+	writeLines(t, filepath.Join(dir, "access.log"), "access_line=2")
+
+	// 重启后每个文件都从上次记录的位点继续采集
+	sink = &mockSink{}
+	harvester = newTestHarvester(t, cfg)
+	harvester.RegisterSink(sink)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	harvester.Run(ctx)
+	sink.waitFor(t, 2)
+	time.Sleep(200 * time.Millisecond)
+
+	actual := sink.messages()
+	sort.Strings(actual)
+	if len(actual) != 2 || actual[0] != "access_line=2" || actual[1] != "app_line=2" {
+		t.Fatalf("unexpect messages after restart : %v", actual)
+	}
+}
+
+func TestHarvester_ReusedKey(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	registry := NewRegistry()
+	track := func(name string, state FileState) {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.State = GetOSState(info)
+		registry.Update(state.State.String(), func(fs *FileState) {
+			*fs = state
+		})
+	}
+
+	// 文件长度小于记录的位点，说明 I-Node 被新的文件复用了
+	small := filepath.Join(dir, "a.log")
+	writeLines(t, small, "a_line=1")
+	track(small, FileState{Source: small, Offset: 100, LastSeen: now, Finished: true})
+
+	// 在其他路径下被发现，并且在最后一次被发现之后又被修改过
+	moved := filepath.Join(dir, "b.log")
+	writeLines(t, moved, "b_line=1")
+	track(moved, FileState{Source: filepath.Join(dir, "old.log"), Offset: 3, LastSeen: now.Add(-time.Hour), Finished: true})
+
+	// 轮转时被重命名的文件没有被修改过，依然视为已经采集完成
+	rotated := filepath.Join(dir, "c.log.1")
+	writeLines(t, rotated, "c_line=1")
+	past := now.Add(-2 * time.Hour)
+	if err := os.Chtimes(rotated, past, past); err != nil {
+		t.Fatal(err)
+	}
+	track(rotated, FileState{Source: filepath.Join(dir, "c.log"), Offset: 9, LastSeen: now.Add(-time.Hour), Finished: true})
+
+	data, err := registry.MarshalJSON()
 	if err != nil {
 		t.Fatal(err)
 	}
+	metaPath := filepath.Join(dir, "meta")
+	if err := ioutil.WriteFile(metaPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
 
-	ctx := context.Background()
+	sink := &mockSink{}
+	harvester := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, ".*\\.log.*"),
+		MetaPath: metaPath,
+	})
+	harvester.RegisterSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	harvester.Run(ctx)
+
+	sink.waitFor(t, 2)
+	time.Sleep(200 * time.Millisecond)
+	actual := sink.messages()
+	sort.Strings(actual)
+	if len(actual) != 2 || actual[0] != "a_line=1" || actual[1] != "b_line=1" {
+		t.Fatalf("reused keys should be harvested from the beginning, actual=%v", actual)
+	}
+}
+
+func TestHarvester_Paths(t *testing.T) {
+	dir := t.TempDir()
+	mkdirAll(t, filepath.Join(dir, "order", "2022"))
+	writeLines(t, filepath.Join(dir, "order", "2022", "app.log"), "order_line=1")
+	writeLines(t, filepath.Join(dir, "order", "debug.log"), "debug_line=1")
+
+	sink := &mockSink{}
+	harvester := newTestHarvester(t, Config{
+		Paths:    []string{filepath.Join(dir, "**", "*.log")},
+		Exclude:  []string{filepath.Join(dir, "**", "debug.log")},
+		MetaPath: filepath.Join(dir, "meta"),
+	})
+	harvester.RegisterSink(sink)
 
-	harvester.RegisterSink(&mockSink{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	harvester.Run(ctx)
+	sink.waitFor(t, 1)
+
+	// 启动之后新创建的子目录下的文件同样需要被采集
+	mkdirAll(t, filepath.Join(dir, "pay"))
+	writeLines(t, filepath.Join(dir, "pay", "app.log"), "pay_line=1")
+	sink.waitFor(t, 2)
+	time.Sleep(200 * time.Millisecond)
+
+	actual := sink.messages()
+	sort.Strings(actual)
+	if len(actual) != 2 || actual[0] != "order_line=1" || actual[1] != "pay_line=1" {
+		t.Fatalf("unexpect messages : %v", actual)
+	}
+}
+
+func TestHarvester_CopyTruncate(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "old_line=1", "old_line=2")
+
+	sink := &mockSink{}
+	beater := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, "app\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+	})
+	beater.RegisterSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 2)
+
+	// logrotate copytruncate：文件被截断后继续写入，需要从文件头重新采集
+	if err := os.Truncate(name, 0); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, name, "new_line=1")
+	sink.waitFor(t, 3)
+	if msgs := sink.messages(); msgs[2] != "new_line=1" {
+		t.Fatalf("truncated file should be read from the beginning, actual=%v", msgs)
+	}
+
+	stat, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state, _ := beater.(*harvester).registry.Get(GetOSState(stat).String())
+		if state.Offset == int64(len("new_line=1\n")) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("offset should be reset after truncate, actual=%d", state.Offset)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestHarvester_Stop(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "app_line=1", "app_line=2")
+
+	sink := &mockAckSink{}
+	beater := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, "app\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+		// 只依赖 Stop 时的最后一次落盘
+		Checkpoint: CheckpointConfig{
+			Interval: time.Hour,
+		},
+	})
+	beater.RegisterAckSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 2)
+
+	// Stop 需要等待已经投递的数据被确认
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		sink.ackAll()
+	}()
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stopCancel()
+	start := time.Now()
+	if err := beater.Stop(stopCtx); err != nil {
+		t.Fatal(err)
+	}
+	if cost := time.Since(start); cost < 200*time.Millisecond {
+		t.Fatalf("stop should wait for in-flight events to be acked, cost=%s", cost)
+	}
+	if workers := len(beater.(*harvester).workers); workers != 0 {
+		t.Fatalf("all workers should exit after stop, actual=%d", workers)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := LoadRegistry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ := registry.Get(GetOSState(stat).String())
+	if state.Offset != stat.Size() {
+		t.Fatalf("final checkpoint should be persisted, expect=%d, actual=%d", stat.Size(), state.Offset)
+	}
+}
+
+func TestHarvester_StopTimeout(t *testing.T) {
+	dir := t.TempDir()
+	writeLines(t, filepath.Join(dir, "app.log"), "app_line=1")
+
+	sink := &mockAckSink{}
+	beater := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, "app\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+	})
+	beater.RegisterAckSink(sink)
 
-	<-ctx.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 1)
+
+	// Sink 一直不确认时，Stop 在 ctx 结束后放弃等待
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer stopCancel()
+	if err := beater.Stop(stopCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, actual=%v", err)
+	}
+}
+
+func TestHarvester_StopBlockedSink(t *testing.T) {
+	dir := t.TempDir()
+	writeLines(t, filepath.Join(dir, "app.log"), "app_line=1")
+
+	sink := &blockingSink{
+		called:  make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	beater := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, "app\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+	})
+	beater.RegisterBatchSink(sink)
+	t.Cleanup(func() {
+		close(sink.release)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	select {
+	case <-sink.called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait for sink to be called timeout")
+	}
+
+	// Sink 一直阻塞在 OnBatch 中时，Stop 在 ctx 结束后依然能够返回
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer stopCancel()
+	start := time.Now()
+	if err := beater.Stop(stopCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, actual=%v", err)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Fatalf("stop should not wait for the blocked sink, cost=%s", cost)
+	}
+}
+
+func newTestHarvester(t *testing.T, cfg Config) Harvester {
+	logger := logrus.New()
+	logger.SetOutput(os.Stdout)
+	cfg.Logger = logger
+
+	harvester, err := NewHarvester(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = harvester.Close()
+	})
+	return harvester
+}
+
+func writeLines(t *testing.T, name string, lines ...string) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for i := range lines {
+		if _, err := f.WriteString(lines[i] + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
 }
 
 type mockSink struct {
+	lock sync.Mutex
+	msgs []string
 }
 
 // OnMessage
 func (s *mockSink) OnMessage(msg string) {
-	fmt.Printf("%s\n", msg)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.msgs = append(s.msgs, msg)
+}
+
+func (s *mockSink) messages() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string{}, s.msgs...)
+}
+
+func (s *mockSink) waitFor(t *testing.T, expect int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(s.messages()) >= expect {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("wait for %d messages timeout, actual=%v", expect, s.messages())
+}
+
+// blockingSink OnBatch 在 release 被关闭之前一直阻塞
+type blockingSink struct {
+	called  chan struct{}
+	release chan struct{}
+}
+
+// OnBatch
+func (s *blockingSink) OnBatch(events []*Event, ack AckFunc) error {
+	select {
+	case s.called <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return nil
 }
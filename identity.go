@@ -0,0 +1,179 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+const (
+	// IdentityInode 通过文件的 I-Node 以及 Device 信息判断是否为同一个文件
+	IdentityInode = "inode"
+	// IdentityFingerprint 通过文件指定位置内容的 sha256 判断是否为同一个文件
+	IdentityFingerprint = "fingerprint"
+
+	// defaultFingerprintLength 默认参与计算指纹的字节数
+	defaultFingerprintLength = 1024
+)
+
+var (
+	// ErrorFileIdentity 不支持的 Config.FileIdentity 配置
+	ErrorFileIdentity error = errors.New("file identity must be inode or fingerprint")
+
+	// ErrorFingerprintConfig 不合法的 Config.Fingerprint 配置
+	ErrorFingerprintConfig error = errors.New("fingerprint offset and length must not be negative")
+
+	// errFingerprintNotReady 文件的长度还不足以计算指纹
+	errFingerprintNotReady error = errors.New("file too small to fingerprint")
+)
+
+// FingerprintConfig FileIdentity 为 fingerprint 时的配置
+type FingerprintConfig struct {
+	// Offset 从文件的哪个位置开始计算指纹，可以用来跳过每个文件都相同的文件头
+	Offset int64
+	// Length 参与计算指纹的字节数，<= 0 时使用默认值 1024
+	// 文件长度不足 Offset + Length 时无法计算指纹，在文件写入足够的数据之前不会被采集
+	// 压缩文件不会再发生变化，长度不足时按照解压后完整的内容计算指纹
+	Length int
+}
+
+func (cfg FingerprintConfig) withDefaults() FingerprintConfig {
+	if cfg.Length <= 0 {
+		cfg.Length = defaultFingerprintLength
+	}
+	return cfg
+}
+
+// validate 检查配置是否合法
+func (cfg FingerprintConfig) validate() error {
+	if cfg.Offset < 0 || cfg.Length < 0 {
+		return ErrorFingerprintConfig
+	}
+	return nil
+}
+
+// fileFingerprint 计算文件 [Offset, Offset + Length) 范围内容的 sha256
+// decompress 不为 nil 时按照解压后的内容计算，使轮转后被压缩的文件与原文件的指纹相同
+// whole 为 true 时，文件已经完整读取但长度不足 Offset + Length，按照完整的内容计算
+//
+//	@param path
+//	@param cfg
+//	@param decompress
+//	@param whole
+//	@return string
+//	@return error
+func fileFingerprint(path string, cfg FingerprintConfig, decompress Decompressor, whole bool) (string, error) {
+	f, err := readOpen(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if decompress != nil {
+		decoder, err := decompress(f)
+		if err != nil {
+			if isIncomplete(err) {
+				return "", errFingerprintNotReady
+			}
+			return "", err
+		}
+		defer decoder.Close()
+		r = decoder
+	}
+
+	// 长度不足时 Offset 之前的内容也需要参与计算，因此一起读取
+	buf := make([]byte, cfg.Offset+int64(cfg.Length))
+	n, err := readFull(r, buf)
+	switch {
+	case err == nil:
+		buf = buf[cfg.Offset:]
+	case err == io.EOF && whole && n > 0:
+		buf = buf[:n]
+	case isIncomplete(err):
+		return "", errFingerprintNotReady
+	default:
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readFull 与 io.ReadFull 相同，但是数据不足时返回底层 Reader 的错误
+// 用于区分压缩文件已经完整读取（io.EOF）还是还没有写完（io.ErrUnexpectedEOF）
+func readFull(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// identify 根据 FileIdentity 计算文件在 Registry 中的 key，返回 false 表示文件暂时不能被采集
+//
+//	@receiver beater
+//	@param item
+//	@return bool
+func (beater *harvester) identify(item *fileInfo) bool {
+	item.state = GetOSState(item)
+	compressed := beater.isCompressed(item.path)
+	if beater.cfg.FileIdentity != IdentityFingerprint && !compressed {
+		item.key = item.state.String()
+		if beater.cfg.Decompress {
+			// 记录文件内容的指纹，用于识别轮转后被压缩的文件
+			fingerprint, err := fileFingerprint(item.path, beater.cfg.Fingerprint, nil, true)
+			if err != nil && !errors.Is(err, errFingerprintNotReady) && !errors.Is(err, os.ErrNotExist) {
+				beater.OnError(err)
+			}
+			item.fingerprint = fingerprint
+		}
+		return true
+	}
+
+	// 压缩文件按照 fingerprint 识别，使其能够与轮转前的原文件对应起来
+	var decompress Decompressor
+	if compressed {
+		decompress, _ = findDecompressor(item.path)
+	} else if item.Size() < beater.cfg.Fingerprint.Offset+int64(beater.cfg.Fingerprint.Length) {
+		return false
+	}
+	// 压缩文件不会再发生变化，长度不足时按照完整的内容计算指纹
+	fingerprint, err := fileFingerprint(item.path, beater.cfg.Fingerprint, decompress, compressed)
+	if err != nil {
+		if !errors.Is(err, errFingerprintNotReady) && !errors.Is(err, os.ErrNotExist) {
+			beater.OnError(err)
+		}
+		return false
+	}
+	item.key = fingerprint
+	item.fingerprint = fingerprint
+	return true
+}
@@ -0,0 +1,133 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileFingerprint(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	if err := ioutil.WriteFile(name, []byte("header\nbody_line=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 跳过每个文件都相同的文件头
+	fingerprint, err := fileFingerprint(name, FingerprintConfig{Offset: 7, Length: 4}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("body"))
+	if fingerprint != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpect fingerprint : %s", fingerprint)
+	}
+
+	if _, err := fileFingerprint(name, FingerprintConfig{Offset: 7, Length: 100}, nil, false); err != errFingerprintNotReady {
+		t.Fatalf("expect errFingerprintNotReady, actual=%v", err)
+	}
+
+	// 长度不足时按照完整的内容计算
+	fingerprint, err = fileFingerprint(name, FingerprintConfig{Offset: 7, Length: 100}, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum = sha256.Sum256([]byte("header\nbody_line=1\n"))
+	if fingerprint != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpect whole content fingerprint : %s", fingerprint)
+	}
+
+	// 还没有写完的压缩文件暂时不能计算指纹
+	gz := filepath.Join(filepath.Dir(name), "app.log.1.gz")
+	writeGzip(t, gz, "header\nbody_line=1\n")
+	data, err := ioutil.ReadFile(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(gz, data[:len(data)-4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileFingerprint(gz, FingerprintConfig{Length: 100}, decompressors[".gz"], true); err != errFingerprintNotReady {
+		t.Fatalf("expect errFingerprintNotReady for incomplete gzip, actual=%v", err)
+	}
+}
+
+func TestHarvester_FingerprintIdentity(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "line=1")
+
+	sink := &mockSink{}
+	beater := newTestHarvester(t, Config{
+		Path:         filepath.Join(dir, "app\\.log$"),
+		MetaPath:     filepath.Join(dir, "meta"),
+		WatchMode:    WatchPoll,
+		FileIdentity: IdentityFingerprint,
+		Fingerprint: FingerprintConfig{
+			Length: 16,
+		},
+	})
+	beater.RegisterSink(sink)
+	beater.(*harvester).scanInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+
+	// 文件长度不足时不会被采集
+	time.Sleep(200 * time.Millisecond)
+	if msgs := sink.messages(); len(msgs) != 0 {
+		t.Fatalf("file too small should not be harvested, actual=%v", msgs)
+	}
+
+	writeLines(t, name, "line=2", "line=3")
+	sink.waitFor(t, 3)
+
+	sum := sha256.Sum256([]byte("line=1\nline=2\nli"))
+	key := hex.EncodeToString(sum[:])
+	state, ok := beater.(*harvester).registry.Get(key)
+	if !ok || state.Fingerprint != key || state.Source != name {
+		t.Fatalf("registry should be keyed by fingerprint, actual=%+v", beater.(*harvester).registry.Snapshot())
+	}
+
+	// copytruncate 之后文件的指纹发生变化，作为新的文件从头开始采集
+	if err := os.Truncate(name, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	writeLines(t, name, "rotated_line=1", "rotated_line=2")
+	sink.waitFor(t, 5)
+	if msgs := sink.messages(); msgs[3] != "rotated_line=1" || msgs[4] != "rotated_line=2" {
+		t.Fatalf("truncated file should be harvested as a new file, actual=%v", msgs)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if msgs := sink.messages(); len(msgs) != 5 {
+		t.Fatalf("truncated file should be harvested only once, actual=%v", msgs)
+	}
+}
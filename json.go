@@ -0,0 +1,167 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	// ErrorJSONNotObject 一行数据不是一个 JSON 对象
+	ErrorJSONNotObject error = errors.New("json line is not an object")
+	// ErrorJSONMessageKey JSON 对象中不存在 JSONConfig.MessageKey 对应的字符串
+	ErrorJSONMessageKey error = errors.New("json message key not found or not a string")
+)
+
+// JSONConfig 将每一行数据按照 JSON 对象解析为 Event.Fields 的配置
+type JSONConfig struct {
+	// Target 解析出的字段放在 Event.Fields 的哪个 key 下，为空时直接合并到 Event.Fields 的根
+	Target string
+	// MessageKey 不为空时，使用 JSON 对象中该 key 对应的字符串替换 Event.Content，例如 docker 日志中的 log
+	// 后续的多行合并等处理都作用于该字符串
+	MessageKey string
+}
+
+// JSONReader 将每一行数据解析为结构化字段的 Reader
+// 无法解析的行不会被丢弃，保留原始内容并在 Event 上标记 FlagJSONError
+type JSONReader struct {
+	reader Reader
+	cfg    JSONConfig
+}
+
+// NewJSONReader 构造一个解析 JSON 的 Reader
+//
+//	@param reader
+//	@param cfg
+//	@return Reader
+func NewJSONReader(reader Reader, cfg JSONConfig) Reader {
+	return &JSONReader{
+		reader: reader,
+		cfg:    cfg,
+	}
+}
+
+// CurFile
+func (jr *JSONReader) CurFile() *os.File {
+	return jr.reader.CurFile()
+}
+
+// Offset
+func (jr *JSONReader) Offset() int64 {
+	return jr.reader.Offset()
+}
+
+// Close
+func (jr *JSONReader) Close() error {
+	return jr.reader.Close()
+}
+
+// Next
+func (jr *JSONReader) Next() (*Event, error) {
+	event, err := jr.reader.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := jr.decode(event); err != nil {
+		event.AddFlag(FlagJSONError)
+	}
+	return event, nil
+}
+
+// decode 解析 Event 的内容并写入 Event.Fields
+func (jr *JSONReader) decode(event *Event) error {
+	fields, err := decodeJSONObject(event.Content)
+	if err != nil {
+		return err
+	}
+	if jr.cfg.Target == "" {
+		for k, v := range fields {
+			event.PutField(k, v)
+		}
+	} else {
+		event.PutField(jr.cfg.Target, fields)
+	}
+	if jr.cfg.MessageKey == "" {
+		return nil
+	}
+	msg, ok := fields[jr.cfg.MessageKey].(string)
+	if !ok {
+		return ErrorJSONMessageKey
+	}
+	event.Content = []byte(msg)
+	return nil
+}
+
+// decodeJSONObject 将一行数据解析为 JSON 对象，整数解析为 int64，其他数字解析为 float64
+//
+//	@param data
+//	@return map
+//	@return error
+func decodeJSONObject(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var val interface{}
+	if err := decoder.Decode(&val); err != nil {
+		return nil, err
+	}
+	// 一行中只允许有一个 JSON 对象
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, ErrorJSONNotObject
+	}
+	fields, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, ErrorJSONNotObject
+	}
+	return convertJSONNumbers(fields).(map[string]interface{}), nil
+}
+
+// convertJSONNumbers 将 json.Number 转换为 int64 或者 float64
+func convertJSONNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = convertJSONNumbers(v[k])
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = convertJSONNumbers(v[i])
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	default:
+		return v
+	}
+}
@@ -0,0 +1,154 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJSONReader(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	writeLines(t, name,
+		`{"level":"info","msg":"started","port":8080,"cost":1.5,"tags":["a",1]}`,
+		`not a json line`,
+		`[1,2,3]`,
+		`{"level":"warn"}`,
+	)
+
+	tests := []struct {
+		cfg    JSONConfig
+		expect []*Event
+	}{
+		{
+			cfg: JSONConfig{},
+			expect: []*Event{
+				{Fields: map[string]interface{}{"level": "info", "msg": "started", "port": int64(8080), "cost": 1.5, "tags": []interface{}{"a", int64(1)}}},
+				{Flags: []string{FlagJSONError}},
+				{Flags: []string{FlagJSONError}},
+				{Fields: map[string]interface{}{"level": "warn"}},
+			},
+		},
+		{
+			cfg: JSONConfig{Target: "json", MessageKey: "msg"},
+			expect: []*Event{
+				{Content: []byte("started"), Fields: map[string]interface{}{"json": map[string]interface{}{"level": "info", "msg": "started", "port": int64(8080), "cost": 1.5, "tags": []interface{}{"a", int64(1)}}}},
+				{Flags: []string{FlagJSONError}},
+				{Flags: []string{FlagJSONError}},
+				{Content: []byte(`{"level":"warn"}`), Fields: map[string]interface{}{"json": map[string]interface{}{"level": "warn"}}, Flags: []string{FlagJSONError}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		offset := int64(0)
+		lr, err := NewLineReaderWithConfig(name, &offset, LineReaderConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := NewJSONReader(lr, tt.cfg)
+		for i := range tt.expect {
+			event, err := reader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.expect[i].Content != nil && event.String() != tt.expect[i].String() {
+				t.Fatalf("%+v line %d expect content=[%s], actual=[%s]", tt.cfg, i, tt.expect[i].String(), event.String())
+			}
+			if !reflect.DeepEqual(event.Fields, tt.expect[i].Fields) || !reflect.DeepEqual(event.Flags, tt.expect[i].Flags) {
+				t.Fatalf("%+v line %d expect=%+v, actual=%+v", tt.cfg, i, tt.expect[i], event)
+			}
+		}
+		reader.Close()
+	}
+}
+
+func TestHarvester_JSON(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	// docker json-file 格式的日志，按照 log 字段进行多行合并
+	writeLines(t, name,
+		`{"log":"panic: boom","stream":"stderr"}`,
+		`{"log":"  at main.go:10","stream":"stderr"}`,
+		`{"log":"next","stream":"stdout"}`,
+	)
+
+	sink := &mockEventSink{}
+	beater := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, "app\\.log"),
+		MetaPath: filepath.Join(dir, "meta"),
+		JSON:     &JSONConfig{MessageKey: "log"},
+		Multiline: &MultilineConfig{
+			Pattern: `^\s+at`,
+		},
+	})
+	beater.RegisterBatchSink(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	writeLines(t, name, `{"log":"last","stream":"stdout"}`)
+	events := sink.waitFor(t, 2)
+
+	if events[0].String() != "panic: boom\n  at main.go:10" || !events[0].HasFlag(FlagMultiline) {
+		t.Fatalf("unexpect event : %+v", events[0])
+	}
+	if stream, _ := events[0].GetField("stream"); stream != "stderr" {
+		t.Fatalf("unexpect stream field : %v", stream)
+	}
+	if events[1].String() != "next" {
+		t.Fatalf("unexpect event : %+v", events[1])
+	}
+}
+
+type mockEventSink struct {
+	lock   sync.Mutex
+	events []*Event
+}
+
+// OnBatch
+func (s *mockEventSink) OnBatch(events []*Event, ack AckFunc) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.events = append(s.events, events...)
+	ack()
+	return nil
+}
+
+func (s *mockEventSink) waitFor(t *testing.T, expect int) []*Event {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		s.lock.Lock()
+		events := append([]*Event{}, s.events...)
+		s.lock.Unlock()
+		if len(events) >= expect {
+			return events
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("wait for %d events timeout", expect)
+	return nil
+}
@@ -0,0 +1,83 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"os"
+	"time"
+)
+
+// CloseConfig 采集中的文件句柄的关闭策略
+type CloseConfig struct {
+	// Inactive 文件超过该时长没有新的数据时关闭文件句柄，文件再次发生变化时重新打开并从记录的位点继续采集
+	// <= 0 表示一直保持打开
+	Inactive time.Duration
+	// Removed 文件被删除后，读到文件末尾时是否关闭文件句柄，为 nil 时默认为 true
+	// 为 false 时会继续读取已经被删除的文件，直到满足 Inactive 的条件
+	Removed *bool
+	// Renamed 文件被重命名后，读到文件末尾时是否关闭文件句柄，为 nil 时默认为 true
+	// 为 false 时会继续读取被重命名后的文件，直到满足 Inactive 的条件
+	Renamed *bool
+}
+
+// closeOnRemoved 文件被删除时是否关闭文件句柄
+func (cfg CloseConfig) closeOnRemoved() bool {
+	return cfg.Removed == nil || *cfg.Removed
+}
+
+// closeOnRenamed 文件被重命名时是否关闭文件句柄
+func (cfg CloseConfig) closeOnRenamed() bool {
+	return cfg.Renamed == nil || *cfg.Renamed
+}
+
+// fileMark 文件因为长时间没有新的数据被关闭时的状态，用于判断文件之后是否发生了变化
+type fileMark struct {
+	modTime time.Time
+	size    int64
+}
+
+// changed 判断文件相对于关闭时是否发生了变化
+func (mark fileMark) changed(info os.FileInfo) bool {
+	return !info.ModTime().Equal(mark.modTime) || info.Size() != mark.size
+}
+
+// isOlder 判断文件的修改时间是否早于 Config.IgnoreOlder
+//
+//	@receiver beater
+//	@param info
+//	@return bool
+func (beater *harvester) isOlder(info os.FileInfo) bool {
+	return beater.cfg.IgnoreOlder > 0 && time.Since(info.ModTime()) > beater.cfg.IgnoreOlder
+}
+
+// closeInactive 记录因为长时间没有新的数据而被关闭的文件，文件发生变化之前不会重新开始采集
+//
+//	@receiver beater
+//	@param worker
+//	@param mark
+func (beater *harvester) closeInactive(worker *fileWorker, mark fileMark) {
+	beater.lock.Lock()
+	defer beater.lock.Unlock()
+
+	beater.inactive[worker.key] = mark
+}
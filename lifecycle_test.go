@@ -0,0 +1,259 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHarvester_IgnoreOlder(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.log")
+	writeLines(t, old, "old_line=1")
+	past := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, filepath.Join(dir, "new.log"), "new_line=1")
+
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Path:        filepath.Join(dir, ".*\\.log$"),
+		MetaPath:    filepath.Join(dir, "meta"),
+		IgnoreOlder: time.Hour,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+
+	sink.waitFor(t, 1)
+	time.Sleep(200 * time.Millisecond)
+	if msgs := sink.messages(); len(msgs) != 1 || msgs[0] != "new_line=1" {
+		t.Fatalf("file older than ignore older should not be harvested, actual=%v", msgs)
+	}
+
+	// 被忽略的文件有新的数据写入后，只采集新写入的数据
+	writeLines(t, old, "old_line=2")
+	sink.waitFor(t, 2)
+	time.Sleep(200 * time.Millisecond)
+	if msgs := sink.messages(); len(msgs) != 2 || msgs[1] != "old_line=2" {
+		t.Fatalf("only new lines of the ignored file should be harvested, actual=%v", msgs)
+	}
+}
+
+func TestHarvester_CloseInactive(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "app_line=1")
+
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Path:     filepath.Join(dir, "app\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+		Close: CloseConfig{
+			Inactive: 200 * time.Millisecond,
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+
+	sink.waitFor(t, 1)
+	waitWorkers(t, beater, 0)
+	// 文件没有变化时不会被重新打开
+	time.Sleep(200 * time.Millisecond)
+	if workers := workerCount(beater); workers != 0 {
+		t.Fatalf("inactive file should not be reopened, actual=%d", workers)
+	}
+
+	// 文件有新的数据写入后重新打开，并从记录的位点继续采集
+	writeLines(t, name, "app_line=2")
+	sink.waitFor(t, 2)
+	waitWorkers(t, beater, 0)
+
+	// 文件关闭期间发生了轮转，新的文件从头开始采集
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, name, "rotated_line=1")
+	sink.waitFor(t, 3)
+	time.Sleep(200 * time.Millisecond)
+
+	msgs := sink.messages()
+	if len(msgs) != 3 || msgs[1] != "app_line=2" || msgs[2] != "rotated_line=1" {
+		t.Fatalf("unexpect messages : %v", msgs)
+	}
+}
+
+func TestHarvester_CloseRenamed(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	keep := false
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Path:     filepath.Join(dir, "app\\.log(\\.1)?$"),
+		MetaPath: filepath.Join(dir, "meta"),
+		Close: CloseConfig{
+			Inactive: 500 * time.Millisecond,
+			Renamed:  &keep,
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+
+	writeString(t, f, "app_line=1\n")
+	sink.waitFor(t, 1)
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 文件被重命名后依然被写入，需要继续采集
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	writeString(t, f, "app_line=2\n")
+	sink.waitFor(t, 2)
+	key := GetOSState(stat).String()
+	waitFor(t, func() bool {
+		state, _ := beater.registry.Get(key)
+		return state.Offset == int64(len("app_line=1\napp_line=2\n"))
+	})
+	// 扫描时已经发现了重命名之后的路径，提交位点时不会把路径改回重命名之前的路径
+	if state, _ := beater.registry.Get(key); state.Source != name+".1" {
+		t.Fatalf("source should be the renamed path, actual=%s", state.Source)
+	}
+
+	// 满足 Inactive 的条件后关闭，文件被标记为采集完成
+	waitWorkers(t, beater, 0)
+	if state, _ := beater.registry.Get(key); !state.Finished {
+		t.Fatalf("renamed file should be finished after close, actual=%+v", state)
+	}
+}
+
+func TestHarvester_CloseRemoved(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sink := &mockSink{}
+	beater := newLifecycleHarvester(t, sink, Config{
+		Path:     filepath.Join(dir, "app\\.log$"),
+		MetaPath: filepath.Join(dir, "meta"),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+
+	writeString(t, f, "app_line=1\n")
+	sink.waitFor(t, 1)
+
+	// 默认情况下文件被删除后就不再采集，即使依然有数据写入
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	waitWorkers(t, beater, 0)
+	writeString(t, f, "app_line=2\n")
+	time.Sleep(200 * time.Millisecond)
+	if msgs := sink.messages(); len(msgs) != 1 {
+		t.Fatalf("removed file should be closed, actual=%v", msgs)
+	}
+
+	// Removed 为 false 时继续采集被删除的文件
+	name = filepath.Join(dir, "other", "app.log")
+	mkdirAll(t, filepath.Dir(name))
+	g, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	keep := false
+	sink = &mockSink{}
+	beater = newLifecycleHarvester(t, sink, Config{
+		Path:     filepath.Join(dir, "other", "app\\.log$"),
+		MetaPath: filepath.Join(dir, "other", "meta"),
+		Close: CloseConfig{
+			Removed: &keep,
+		},
+	})
+	beater.Run(ctx)
+
+	writeString(t, g, "app_line=1\n")
+	sink.waitFor(t, 1)
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	writeString(t, g, "app_line=2\n")
+	sink.waitFor(t, 2)
+}
+
+// newLifecycleHarvester 使用轮询的方式，并缩短扫描间隔，加快文件状态变化的发现
+func newLifecycleHarvester(t *testing.T, sink Sink, cfg Config) *harvester {
+	cfg.WatchMode = WatchPoll
+	beater := newTestHarvester(t, cfg).(*harvester)
+	beater.scanInterval = 50 * time.Millisecond
+	beater.RegisterSink(sink)
+	return beater
+}
+
+func workerCount(beater *harvester) int {
+	beater.lock.RLock()
+	defer beater.lock.RUnlock()
+
+	return len(beater.workers)
+}
+
+func waitWorkers(t *testing.T, beater *harvester, expect int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if workerCount(beater) == expect {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("wait for %d workers timeout, actual=%d", expect, workerCount(beater))
+}
+
+func writeString(t *testing.T, f *os.File, content string) {
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -22,7 +22,30 @@
 
 package filebeat
 
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// registryVersion 当前 registry 持久化格式的版本
+	registryVersion = 1
+)
+
+var (
+	// ErrorInvalidStateKey 无法解析的文件 StateOS 信息
+	ErrorInvalidStateKey error = errors.New("invalid file state key")
+
+	// ErrorInvalidMetadata MetaPath 中的内容不是合法的 JSON
+	ErrorInvalidMetadata error = errors.New("invalid metadata content")
+)
+
 // Metadata 记录文件处理信息数据
+// 旧版本只支持记录单个文件的处理信息，目前仅用于兼容读取旧版本的 MetaPath 文件
 type Metadata struct {
 	// CurFile 正在处理的文件
 	CurFile string
@@ -33,3 +56,262 @@ type Metadata struct {
 	// PreFileINode 上一个被处理完的文件的 INode 信息
 	PreFileINode string
 }
+
+// FileState 单个文件的处理信息
+type FileState struct {
+	// Source 文件路径
+	Source string `json:"source"`
+	// State 文件的 INode 信息
+	State StateOS `json:"state"`
+	// Fingerprint FileIdentity 为 fingerprint 时文件内容的指纹，此时 Registry 的 key 为该指纹
+	// 开启 Decompress 时也会记录，用于识别轮转后被压缩的文件
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Offset 已经处理完成的位点信息
+	Offset int64 `json:"offset"`
+	// LastSeen 最近一次在磁盘上发现该文件的时间
+	LastSeen time.Time `json:"last_seen"`
+	// Finished 文件是否已经采集完成（被删除或者被重命名）
+	Finished bool `json:"finished"`
+}
+
+// Registry 记录所有文件的处理信息，key 为文件的 StateOS 信息或者内容指纹
+type Registry struct {
+	lock  sync.RWMutex
+	files map[string]*FileState
+	// legacy 从旧版本 Metadata 迁移时当前处理文件的 StateOS 信息，启动后首次扫描时用于识别已经处理完的历史文件
+	legacy string
+}
+
+// registryFile registry 持久化到 MetaPath 中的格式
+type registryFile struct {
+	Version int                   `json:"version"`
+	Files   map[string]*FileState `json:"files"`
+}
+
+// NewRegistry 创建一个空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		files: make(map[string]*FileState),
+	}
+}
+
+// LoadRegistry 从 MetaPath 的内容中恢复 Registry，兼容旧版本的单文件 Metadata 格式
+//
+//	@param data
+//	@return *Registry
+//	@return error
+func LoadRegistry(data []byte) (*Registry, error) {
+	registry := NewRegistry()
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return registry, nil
+	}
+	if !json.Valid(data) {
+		return nil, ErrorInvalidMetadata
+	}
+
+	probe := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+
+	if _, ok := probe["version"]; ok {
+		file := registryFile{}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		for key, state := range file.Files {
+			if state != nil {
+				registry.files[key] = state
+			}
+		}
+		return registry, nil
+	}
+
+	// 旧版本的单文件 Metadata 格式
+	meta := Metadata{}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	if err := registry.loadMetadata(meta); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// loadMetadata 将旧版本的 Metadata 转换为 Registry 中的记录
+func (r *Registry) loadMetadata(meta Metadata) error {
+	now := time.Now()
+	if meta.PreFileINode != "" && meta.PreFileINode != meta.CurFileINode {
+		state, err := parseStateKey(meta.PreFileINode)
+		if err != nil {
+			return err
+		}
+		r.files[meta.PreFileINode] = &FileState{
+			State:    state,
+			LastSeen: now,
+			Finished: true,
+		}
+	}
+	if meta.CurFileINode != "" {
+		state, err := parseStateKey(meta.CurFileINode)
+		if err != nil {
+			return err
+		}
+		offset := meta.CurOffset
+		// 旧版本记录的位点指向最后一个已读取行的换行符，需要跳过该换行符
+		if offset != 0 {
+			offset++
+		}
+		r.files[meta.CurFileINode] = &FileState{
+			Source:   meta.CurFile,
+			State:    state,
+			Offset:   offset,
+			LastSeen: now,
+		}
+		r.legacy = meta.CurFileINode
+	}
+	return nil
+}
+
+// legacyModTime 获取旧版本 Metadata 中当前处理文件的修改时间，旧版本认为修改时间不晚于该文件的其他文件都已经处理完成
+// 不是从旧版本迁移或者当前处理文件已经不存在时返回零值
+//
+//	@receiver beater
+//	@param files
+//	@return time.Time
+func (beater *harvester) legacyModTime(files []fileInfo) time.Time {
+	if beater.registry.legacy == "" {
+		return time.Time{}
+	}
+	for i := range files {
+		if GetOSState(files[i]).String() == beater.registry.legacy {
+			return files[i].ModTime()
+		}
+	}
+	return time.Time{}
+}
+
+// Get 获取指定文件的处理信息
+//
+//	@receiver r
+//	@param key
+//	@return FileState
+//	@return bool
+func (r *Registry) Get(key string) (FileState, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	state, ok := r.files[key]
+	if !ok {
+		return FileState{}, false
+	}
+	return *state, true
+}
+
+// Update 更新指定文件的处理信息，如果不存在则会创建一条新的记录
+//
+//	@receiver r
+//	@param key
+//	@param fn
+func (r *Registry) Update(key string, fn func(state *FileState)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	state, ok := r.files[key]
+	if !ok {
+		state = &FileState{}
+		r.files[key] = state
+	}
+	fn(state)
+}
+
+// findFingerprint 查找内容指纹相同的文件的处理信息，存在多个时优先返回最后记录的路径为 source 的文件
+//
+//	@receiver r
+//	@param fingerprint
+//	@param source
+//	@return string
+//	@return FileState
+//	@return bool
+func (r *Registry) findFingerprint(fingerprint, source string) (string, FileState, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if fingerprint == "" {
+		return "", FileState{}, false
+	}
+	found := ""
+	for key, state := range r.files {
+		if state.Fingerprint != fingerprint {
+			continue
+		}
+		if state.Source == source {
+			return key, *state, true
+		}
+		if found == "" {
+			found = key
+		}
+	}
+	if found == "" {
+		return "", FileState{}, false
+	}
+	return found, *r.files[found], true
+}
+
+// Remove 删除指定文件的处理信息
+//
+//	@receiver r
+//	@param key
+func (r *Registry) Remove(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.files, key)
+}
+
+// Snapshot 获取当前所有文件处理信息的快照
+//
+//	@receiver r
+//	@return map[string]FileState
+func (r *Registry) Snapshot() map[string]FileState {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	ret := make(map[string]FileState, len(r.files))
+	for key, state := range r.files {
+		ret[key] = *state
+	}
+	return ret
+}
+
+// MarshalJSON
+//
+//	@receiver r
+//	@return []byte
+//	@return error
+func (r *Registry) MarshalJSON() ([]byte, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return json.Marshal(registryFile{
+		Version: registryVersion,
+		Files:   r.files,
+	})
+}
+
+// parseStateKey 将 StateOS.String() 的结果解析回 StateOS
+func parseStateKey(key string) (StateOS, error) {
+	pos := strings.LastIndexByte(key, '-')
+	if pos <= 0 {
+		return StateOS{}, ErrorInvalidStateKey
+	}
+	inode, err := strconv.ParseUint(key[:pos], 10, 64)
+	if err != nil {
+		return StateOS{}, ErrorInvalidStateKey
+	}
+	device, err := strconv.ParseUint(key[pos+1:], 10, 64)
+	if err != nil {
+		return StateOS{}, ErrorInvalidStateKey
+	}
+	return StateOS{Inode: inode, Device: device}, nil
+}
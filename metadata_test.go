@@ -0,0 +1,172 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadRegistry(t *testing.T) {
+	registry := NewRegistry()
+	registry.Update("1-2", func(state *FileState) {
+		state.Source = "/var/log/app.log"
+		state.State = StateOS{Inode: 1, Device: 2}
+		state.Offset = 100
+	})
+	registry.Update("3-2", func(state *FileState) {
+		state.Source = "/var/log/app.log.1"
+		state.State = StateOS{Inode: 3, Device: 2}
+		state.Offset = 20
+		state.Finished = true
+	})
+
+	data, err := json.Marshal(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadRegistry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := registry.Snapshot()
+	actual := loaded.Snapshot()
+	if len(expect) != len(actual) {
+		t.Fatalf("no equal, expect=[%v], acutal=[%v]", expect, actual)
+	}
+	for key := range expect {
+		if expect[key].Source != actual[key].Source || expect[key].Offset != actual[key].Offset ||
+			expect[key].Finished != actual[key].Finished || !expect[key].State.isSame(actual[key].State) {
+			t.Fatalf("no equal, expect=[%v], acutal=[%v]", expect[key], actual[key])
+		}
+	}
+}
+
+func TestLoadRegistry_Metadata(t *testing.T) {
+	data, err := json.Marshal(Metadata{
+		CurFile:      "/var/log/app.log",
+		CurFileINode: "10-2",
+		CurOffset:    15,
+		PreFileINode: "9-2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := LoadRegistry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cur, ok := registry.Get("10-2")
+	if !ok {
+		t.Fatal("current file state not found")
+	}
+	if cur.Source != "/var/log/app.log" || cur.Offset != 16 || cur.Finished {
+		t.Fatalf("unexpect current file state : %+v", cur)
+	}
+	if cur.State.Inode != 10 || cur.State.Device != 2 {
+		t.Fatalf("unexpect current file inode : %+v", cur.State)
+	}
+
+	pre, ok := registry.Get("9-2")
+	if !ok || !pre.Finished {
+		t.Fatalf("previous file should be finished : %+v", pre)
+	}
+}
+
+func TestLoadRegistry_Invalid(t *testing.T) {
+	if _, err := LoadRegistry([]byte(`{"version":1,"files":{"1-2":{"sou`)); !errors.Is(err, ErrorInvalidMetadata) {
+		t.Fatalf("truncated metadata should be rejected : %v", err)
+	}
+
+	registry, err := LoadRegistry([]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registry.Snapshot()) != 0 {
+		t.Fatal("empty metadata should load an empty registry")
+	}
+}
+
+func TestHarvester_LegacyMetadata(t *testing.T) {
+	dir := t.TempDir()
+	cur := filepath.Join(dir, "app.log")
+	rotated := filepath.Join(dir, "app.log.1")
+	writeLines(t, rotated, "old_1", "old_2")
+	// 旧版本认为修改时间早于当前处理文件的 app.log.1 已经处理完成
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(rotated, past, past); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, cur, "cur_1", "cur_2")
+
+	info, err := os.Stat(cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(Metadata{
+		CurFile:      cur,
+		CurFileINode: GetOSState(info).String(),
+		CurOffset:    int64(len("cur_1")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaPath := filepath.Join(dir, "meta")
+	if err := ioutil.WriteFile(metaPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &mockSink{}
+	harvester := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, "app\\.log.*"),
+		MetaPath: metaPath,
+	})
+	harvester.RegisterSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	harvester.Run(ctx)
+
+	sink.waitFor(t, 1)
+	time.Sleep(200 * time.Millisecond)
+	if msgs := sink.messages(); len(msgs) != 1 || msgs[0] != "cur_2" {
+		t.Fatalf("only new lines of current file should be harvested, actual=%v", msgs)
+	}
+
+	// 历史文件之后追加的数据依然需要采集
+	writeLines(t, rotated, "old_3")
+	sink.waitFor(t, 2)
+	if msgs := sink.messages(); msgs[1] != "old_3" {
+		t.Fatalf("appended line of rotated file should be harvested, actual=%v", msgs)
+	}
+}
@@ -0,0 +1,236 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+const (
+	// MultilineMatchAfter 匹配的行合并到前一行之后
+	MultilineMatchAfter = "after"
+	// MultilineMatchBefore 匹配的行合并到下一行之前
+	MultilineMatchBefore = "before"
+
+	// defaultMultilineMaxLines 一条多行事件默认最多合并的行数
+	defaultMultilineMaxLines = 500
+	// defaultMultilineMaxBytes 一条多行事件默认最多的字节数
+	defaultMultilineMaxBytes = 10 << 20
+	// defaultMultilineFlushTimeout 文件空闲多久之后，默认将尚未结束的多行事件直接投递
+	defaultMultilineFlushTimeout = 5 * time.Second
+)
+
+var (
+	// ErrorMultilineMatch 不支持的 MultilineConfig.Match 配置
+	ErrorMultilineMatch error = errors.New("multiline match must be after or before")
+)
+
+// MultilineConfig 多行合并的配置，语义与 filebeat 的 multiline.pattern 保持一致
+//
+//   - Negate = false, Match = after：匹配 Pattern 的行合并到前一个不匹配的行之后，Pattern 为续行的规则
+//   - Negate = true, Match = after：不匹配 Pattern 的行合并到前一个匹配的行之后，Pattern 为起始行的规则
+//   - Negate = false, Match = before：匹配 Pattern 的行合并到下一个不匹配的行之前
+//   - Negate = true, Match = before：不匹配 Pattern 的行合并到下一个匹配的行之前
+type MultilineConfig struct {
+	// Pattern 用于判断行的正则表达式
+	Pattern string
+	// Negate 是否对 Pattern 的匹配结果取反
+	Negate bool
+	// Match 合并的方向，after 或者 before，为空时默认为 after
+	Match string
+	// MaxLines 一条事件最多合并的行数，超过的行会被丢弃，但是位点依然会前进，<= 0 时使用默认值 500
+	MaxLines int
+	// MaxBytes 一条事件内容最多的字节数，超过的部分会被丢弃，<= 0 时使用默认值 10MiB
+	MaxBytes int
+	// FlushTimeout 文件超过多久没有新的数据时，将尚未结束的事件直接投递，<= 0 时使用默认值 5s
+	FlushTimeout time.Duration
+}
+
+func (cfg MultilineConfig) withDefaults() MultilineConfig {
+	if cfg.Match == "" {
+		cfg.Match = MultilineMatchAfter
+	}
+	if cfg.MaxLines <= 0 {
+		cfg.MaxLines = defaultMultilineMaxLines
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultMultilineMaxBytes
+	}
+	if cfg.FlushTimeout <= 0 {
+		cfg.FlushTimeout = defaultMultilineFlushTimeout
+	}
+	return cfg
+}
+
+// validate 检查配置是否合法
+func (cfg MultilineConfig) validate() error {
+	cfg = cfg.withDefaults()
+	if cfg.Match != MultilineMatchAfter && cfg.Match != MultilineMatchBefore {
+		return ErrorMultilineMatch
+	}
+	_, err := regexp.Compile(cfg.Pattern)
+	return err
+}
+
+// MultilineReader 将多行数据合并为一条 Event 的 Reader，例如异常堆栈
+type MultilineReader struct {
+	reader  Reader
+	cfg     MultilineConfig
+	pattern *regexp.Regexp
+
+	// buffer 正在合并中的事件
+	buffer *Event
+	// lines 正在合并中的事件已经包含的行数
+	lines int
+	// lastAppend 最近一次有行合并进来的时间
+	lastAppend time.Time
+	// pendingErr 投递正在合并中的事件之后需要返回的错误
+	pendingErr error
+}
+
+// NewMultilineReader 构造一个多行合并的 Reader
+//
+//	@param reader
+//	@param cfg
+//	@return Reader
+//	@return error
+func NewMultilineReader(reader Reader, cfg MultilineConfig) (Reader, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	cfg = cfg.withDefaults()
+	return &MultilineReader{
+		reader:  reader,
+		cfg:     cfg,
+		pattern: regexp.MustCompile(cfg.Pattern),
+	}, nil
+}
+
+// CurFile
+func (ml *MultilineReader) CurFile() *os.File {
+	return ml.reader.CurFile()
+}
+
+// Offset 如果有正在合并中的事件，返回该事件的起始位点
+func (ml *MultilineReader) Offset() int64 {
+	if ml.buffer != nil {
+		return ml.buffer.Offset
+	}
+	return ml.reader.Offset()
+}
+
+// Close
+func (ml *MultilineReader) Close() error {
+	return ml.reader.Close()
+}
+
+// Next
+func (ml *MultilineReader) Next() (*Event, error) {
+	if err := ml.pendingErr; err != nil {
+		ml.pendingErr = nil
+		return nil, err
+	}
+	for {
+		event, err := ml.reader.Next()
+		if err != nil {
+			if ml.buffer == nil {
+				return nil, err
+			}
+			// 文件空闲的时间还没有超过 FlushTimeout，继续等待后续的行
+			if errors.Is(err, io.EOF) && time.Since(ml.lastAppend) < ml.cfg.FlushTimeout {
+				return nil, err
+			}
+			// 底层 Reader 已经从文件头重新开始读取了，不会再次返回该错误，需要在投递完当前事件后返回
+			if errors.Is(err, ErrorTruncated) {
+				ml.pendingErr = err
+			}
+			// 先把正在合并中的事件投递出去，底层 Reader 下次依然会返回该错误
+			return ml.flush(), nil
+		}
+
+		matched := ml.pattern.Match(event.Content) != ml.cfg.Negate
+		if ml.cfg.Match == MultilineMatchBefore {
+			// 匹配的行属于下一行，不匹配的行是当前事件的最后一行
+			ml.append(event)
+			if !matched {
+				return ml.flush(), nil
+			}
+			continue
+		}
+
+		// 匹配的行属于前一行，不匹配的行是新事件的第一行
+		if matched || ml.buffer == nil {
+			ml.append(event)
+			continue
+		}
+		ret := ml.flush()
+		ml.append(event)
+		return ret, nil
+	}
+}
+
+// append 将一行数据合并到当前事件中
+func (ml *MultilineReader) append(event *Event) {
+	ml.lastAppend = time.Now()
+	if ml.buffer == nil {
+		ml.buffer = event
+		ml.lines = 1
+		ml.truncate()
+		return
+	}
+
+	ml.buffer.EndOffset = event.EndOffset
+	ml.lines++
+	if ml.lines > ml.cfg.MaxLines {
+		ml.buffer.AddFlag(FlagTruncated)
+		return
+	}
+	ml.buffer.Content = append(ml.buffer.Content, '\n')
+	ml.buffer.Content = append(ml.buffer.Content, event.Content...)
+	// 后续的行被截断或者存在编码错误时，合并后的事件也需要带上对应的标记，扩展字段以第一行为准
+	for i := range event.Flags {
+		ml.buffer.AddFlag(event.Flags[i])
+	}
+	ml.buffer.AddFlag(FlagMultiline)
+	ml.truncate()
+}
+
+// truncate 丢弃超过 MaxBytes 的内容，不会截断多字节的 UTF-8 字符
+func (ml *MultilineReader) truncate() {
+	if len(ml.buffer.Content) > ml.cfg.MaxBytes {
+		ml.buffer.Content = truncateUTF8(ml.buffer.Content, ml.cfg.MaxBytes)
+		ml.buffer.AddFlag(FlagTruncated)
+	}
+}
+
+// flush 结束当前事件的合并
+func (ml *MultilineReader) flush() *Event {
+	event := ml.buffer
+	ml.buffer = nil
+	ml.lines = 0
+	return event
+}
@@ -0,0 +1,188 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	filebeat "github.com/chuntaojun/easy-filebeat"
+)
+
+func Test_MultilineReader(t *testing.T) {
+	lines := []string{
+		"2022-10-01 10:00:00 ERROR request fail",
+		"java.lang.NullPointerException: null",
+		"\tat com.example.Foo.bar(Foo.java:10)",
+		"\tat com.example.Foo.main(Foo.java:5)",
+		"2022-10-01 10:00:01 INFO request success",
+		"2022-10-01 10:00:02 ERROR request fail again",
+		"\tat com.example.Foo.bar(Foo.java:10)",
+	}
+	expect := []string{
+		strings.Join(lines[0:4], "\n"),
+		lines[4],
+		strings.Join(lines[5:7], "\n"),
+	}
+
+	reader := newMultilineReader(t, lines, filebeat.MultilineConfig{
+		Pattern:      `^\d{4}-\d{2}-\d{2}`,
+		Negate:       true,
+		Match:        filebeat.MultilineMatchAfter,
+		FlushTimeout: 50 * time.Millisecond,
+	})
+
+	events := readAll(t, reader, len(expect), time.Second)
+	for i := range expect {
+		if events[i].String() != expect[i] {
+			t.Fatalf("no equal, expect=[%s], acutal=[%s]", expect[i], events[i].String())
+		}
+	}
+	if !events[0].HasFlag(filebeat.FlagMultiline) || events[1].HasFlag(filebeat.FlagMultiline) {
+		t.Fatalf("unexpect flags : %v, %v", events[0].Flags, events[1].Flags)
+	}
+	if events[0].Offset != 0 || events[0].EndOffset != events[1].Offset {
+		t.Fatalf("unexpect offset, first=[%d, %d], second=%d", events[0].Offset, events[0].EndOffset, events[1].Offset)
+	}
+}
+
+func Test_MultilineReaderBefore(t *testing.T) {
+	lines := []string{
+		"first line \\",
+		"continue line \\",
+		"last line",
+		"single line",
+	}
+	reader := newMultilineReader(t, lines, filebeat.MultilineConfig{
+		Pattern: `\\$`,
+		Match:   filebeat.MultilineMatchBefore,
+	})
+
+	events := readAll(t, reader, 2, time.Second)
+	if events[0].String() != strings.Join(lines[0:3], "\n") || events[1].String() != lines[3] {
+		t.Fatalf("unexpect events : [%s], [%s]", events[0].String(), events[1].String())
+	}
+}
+
+func Test_MultilineReaderMaxLines(t *testing.T) {
+	lines := []string{"start", " line=1", " line=2", " line=3", "next"}
+	reader := newMultilineReader(t, lines, filebeat.MultilineConfig{
+		Pattern:  `^\s`,
+		MaxLines: 2,
+	})
+
+	events := readAll(t, reader, 1, time.Second)
+	if events[0].String() != "start\n line=1" || !events[0].HasFlag(filebeat.FlagTruncated) {
+		t.Fatalf("unexpect event : [%s], flags=%v", events[0].String(), events[0].Flags)
+	}
+	// 被丢弃的行的位点依然会前进
+	if events[0].EndOffset != int64(len(strings.Join(lines[:4], "\n"))+1) {
+		t.Fatalf("unexpect end offset : %d", events[0].EndOffset)
+	}
+}
+
+func Test_MultilineReaderMaxBytes(t *testing.T) {
+	lines := []string{"start", " 中文中文", "next"}
+	reader := newMultilineReader(t, lines, filebeat.MultilineConfig{
+		Pattern:  `^\s`,
+		MaxBytes: 8,
+	})
+
+	// 截断的位置位于 中 的中间，需要退回到完整的字符之后
+	events := readAll(t, reader, 1, time.Second)
+	if events[0].String() != "start\n " || !utf8.Valid(events[0].Content) || !events[0].HasFlag(filebeat.FlagTruncated) {
+		t.Fatalf("unexpect event : [%q], flags=%v", events[0].String(), events[0].Flags)
+	}
+}
+
+func Test_MultilineReaderFlags(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "multiline.log")
+	if err := ioutil.WriteFile(name, []byte("start\n line=123456789\nnext\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(0)
+	reader, err := filebeat.NewLineReaderWithConfig(name, &offset, filebeat.LineReaderConfig{MaxBytes: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err = filebeat.NewMultilineReader(reader, filebeat.MultilineConfig{
+		Pattern: `^\s`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// 第一行之后的行被截断时，合并后的事件依然带有截断的标记
+	events := readAll(t, reader, 1, time.Second)
+	if events[0].String() != "start\n line=12" || !events[0].HasFlag(filebeat.FlagTruncated) || !events[0].HasFlag(filebeat.FlagMultiline) {
+		t.Fatalf("unexpect event : [%s], flags=%v", events[0].String(), events[0].Flags)
+	}
+}
+
+func newMultilineReader(t *testing.T, lines []string, cfg filebeat.MultilineConfig) filebeat.Reader {
+	name := filepath.Join(t.TempDir(), "multiline.log")
+	if err := ioutil.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	offset := int64(0)
+	reader, err := filebeat.NewLineReader(name, &offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err = filebeat.NewMultilineReader(reader, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		reader.Close()
+	})
+	return reader
+}
+
+func readAll(t *testing.T, reader filebeat.Reader, expect int, timeout time.Duration) []*filebeat.Event {
+	events := make([]*filebeat.Event, 0, expect)
+	deadline := time.Now().Add(timeout)
+	for len(events) < expect && time.Now().Before(deadline) {
+		event, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if len(events) < expect {
+		t.Fatalf("expect read %d events, actual=%d", expect, len(events))
+	}
+	return events
+}
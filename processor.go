@@ -0,0 +1,351 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+)
+
+const (
+	// ProcessorOnErrorPass Processor 处理失败时保留该事件，标记 FlagProcessorError 后继续执行后续的 Processor
+	ProcessorOnErrorPass = "pass"
+	// ProcessorOnErrorDrop Processor 处理失败时丢弃该事件
+	ProcessorOnErrorDrop = "drop"
+)
+
+var (
+	// ErrorUnknownProcessor 没有注册该名称的 Processor
+	ErrorUnknownProcessor error = errors.New("unknown processor type")
+	// ErrorProcessorOnError 不支持的 ProcessorConfig.OnError 配置
+	ErrorProcessorOnError error = errors.New("processor on error must be pass or drop")
+	// ErrorProcessorOptions Processor 的参数不合法
+	ErrorProcessorOptions error = errors.New("invalid processor options")
+
+	processorLock sync.RWMutex
+	// processorFactories 内置以及通过 RegisterProcessor 注册的 Processor
+	processorFactories = map[string]ProcessorFactory{
+		"add_fields":  newAddFieldsProcessor,
+		"drop_fields": newDropFieldsProcessor,
+		"split":       newSplitProcessor,
+		"grok":        newGrokProcessor,
+		"dissect":     newDissectProcessor,
+		"timestamp":   newTimestampProcessor,
+	}
+)
+
+// Processor 在数据投递给 Sink 之前对事件进行处理，例如过滤、补充字段
+type Processor interface {
+	// Process 处理一条事件，可以直接修改该事件
+	// 返回空列表表示丢弃该事件，返回多条事件表示将该事件拆分为多条
+	Process(event *Event) ([]*Event, error)
+}
+
+// ProcessorFunc 将函数适配为 Processor
+type ProcessorFunc func(event *Event) ([]*Event, error)
+
+// Process
+func (f ProcessorFunc) Process(event *Event) ([]*Event, error) {
+	return f(event)
+}
+
+// ProcessorFactory 根据 ProcessorConfig.Options 构造 Processor
+type ProcessorFactory func(options map[string]interface{}) (Processor, error)
+
+// RegisterProcessor 注册 Processor，内置了 add_fields、drop_fields、split、grok、dissect、timestamp
+//
+//	@param name
+//	@param factory
+func RegisterProcessor(name string, factory ProcessorFactory) {
+	processorLock.Lock()
+	defer processorLock.Unlock()
+
+	processorFactories[name] = factory
+}
+
+// findProcessor 根据名称查找 Processor
+func findProcessor(name string) (ProcessorFactory, error) {
+	processorLock.RLock()
+	defer processorLock.RUnlock()
+
+	factory, ok := processorFactories[name]
+	if !ok {
+		return nil, ErrorUnknownProcessor
+	}
+	return factory, nil
+}
+
+// ProcessorConfig Processor 的配置
+type ProcessorConfig struct {
+	// Type 通过 RegisterProcessor 注册的名称，Processor 不为空时忽略该配置
+	Type string
+	// Options 构造 Processor 的参数
+	Options map[string]interface{}
+	// Processor 自定义的 Processor 实例
+	Processor Processor
+	// OnError 处理失败时的策略，pass 或者 drop，为空时默认为 pass
+	OnError string
+}
+
+// ProcessorError Processor 处理失败的错误信息
+type ProcessorError struct {
+	// Processor 处理失败的 Processor 名称
+	Processor string
+	// Source 事件所在的文件路径
+	Source string
+	// Err 处理失败的原因
+	Err error
+}
+
+// Error
+func (e *ProcessorError) Error() string {
+	return "processor " + e.Processor + " fail, source : " + e.Source + ", " + e.Err.Error()
+}
+
+// Unwrap
+func (e *ProcessorError) Unwrap() error {
+	return e.Err
+}
+
+// processorItem 处理链中的一个 Processor
+type processorItem struct {
+	name      string
+	processor Processor
+	onError   string
+}
+
+// processorChain 按照顺序执行的 Processor 列表
+type processorChain []processorItem
+
+// newProcessorChain 根据配置构造 Processor 处理链
+//
+//	@param cfgs
+//	@return processorChain
+//	@return error
+func newProcessorChain(cfgs []ProcessorConfig) (processorChain, error) {
+	chain := make(processorChain, 0, len(cfgs))
+	for i := range cfgs {
+		cfg := cfgs[i]
+		if cfg.OnError == "" {
+			cfg.OnError = ProcessorOnErrorPass
+		}
+		if cfg.OnError != ProcessorOnErrorPass && cfg.OnError != ProcessorOnErrorDrop {
+			return nil, ErrorProcessorOnError
+		}
+		item := processorItem{
+			name:      cfg.Type,
+			processor: cfg.Processor,
+			onError:   cfg.OnError,
+		}
+		if item.processor == nil {
+			factory, err := findProcessor(cfg.Type)
+			if err != nil {
+				return nil, err
+			}
+			processor, err := factory(cfg.Options)
+			if err != nil {
+				return nil, err
+			}
+			item.processor = processor
+		}
+		chain = append(chain, item)
+	}
+	return chain, nil
+}
+
+// run 按照顺序执行所有的 Processor，返回需要投递给 Sink 的事件
+//
+//	@receiver chain
+//	@param event
+//	@param onError
+//	@return []*Event
+func (chain processorChain) run(event *Event, onError func(err error)) []*Event {
+	events := []*Event{event}
+	for i := range chain {
+		next := make([]*Event, 0, len(events))
+		for _, item := range events {
+			ret, err := chain[i].processor.Process(item)
+			if err == nil {
+				next = append(next, ret...)
+				continue
+			}
+			onError(&ProcessorError{Processor: chain[i].name, Source: item.Source, Err: err})
+			if chain[i].onError == ProcessorOnErrorPass {
+				item.AddFlag(FlagProcessorError)
+				next = append(next, item)
+			}
+		}
+		events = next
+		if len(events) == 0 {
+			break
+		}
+	}
+	return events
+}
+
+// addFieldsProcessor 为事件添加固定的字段
+//
+//   - fields：需要添加的字段，map[string]interface{}
+//   - target：字段放在 Event.Fields 的哪个 key 下，为空时直接添加到 Event.Fields 的根
+type addFieldsProcessor struct {
+	fields map[string]interface{}
+	target string
+}
+
+func newAddFieldsProcessor(options map[string]interface{}) (Processor, error) {
+	fields, ok := options["fields"].(map[string]interface{})
+	if !ok {
+		return nil, ErrorProcessorOptions
+	}
+	target, err := optionString(options, "target")
+	if err != nil {
+		return nil, err
+	}
+	return &addFieldsProcessor{fields: fields, target: target}, nil
+}
+
+// Process
+func (p *addFieldsProcessor) Process(event *Event) ([]*Event, error) {
+	if p.target == "" {
+		for k, v := range p.fields {
+			event.PutField(k, v)
+		}
+		return []*Event{event}, nil
+	}
+	sub, ok := event.Fields[p.target].(map[string]interface{})
+	if !ok {
+		sub = make(map[string]interface{}, len(p.fields))
+		event.PutField(p.target, sub)
+	}
+	for k, v := range p.fields {
+		sub[k] = v
+	}
+	return []*Event{event}, nil
+}
+
+// dropFieldsProcessor 删除事件中的字段
+//
+//   - fields：需要删除的字段名称，[]string
+type dropFieldsProcessor struct {
+	fields []string
+}
+
+func newDropFieldsProcessor(options map[string]interface{}) (Processor, error) {
+	fields, err := optionStrings(options, "fields")
+	if err != nil {
+		return nil, err
+	}
+	return &dropFieldsProcessor{fields: fields}, nil
+}
+
+// Process
+func (p *dropFieldsProcessor) Process(event *Event) ([]*Event, error) {
+	for i := range p.fields {
+		delete(event.Fields, p.fields[i])
+	}
+	return []*Event{event}, nil
+}
+
+// splitProcessor 按照分隔符将事件的内容拆分为多条事件，空的内容会被丢弃
+//
+//   - separator：分隔符，为空时默认为 \n
+type splitProcessor struct {
+	separator []byte
+}
+
+func newSplitProcessor(options map[string]interface{}) (Processor, error) {
+	separator, err := optionString(options, "separator")
+	if err != nil {
+		return nil, err
+	}
+	if separator == "" {
+		separator = "\n"
+	}
+	return &splitProcessor{separator: []byte(separator)}, nil
+}
+
+// Process
+func (p *splitProcessor) Process(event *Event) ([]*Event, error) {
+	parts := bytes.Split(event.Content, p.separator)
+	ret := make([]*Event, 0, len(parts))
+	for i := range parts {
+		if len(parts[i]) == 0 {
+			continue
+		}
+		item := *event
+		item.Content = parts[i]
+		item.Fields = copyFields(event.Fields)
+		item.Flags = append([]string(nil), event.Flags...)
+		ret = append(ret, &item)
+	}
+	return ret, nil
+}
+
+// copyFields 浅拷贝事件的扩展字段
+func copyFields(fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		return nil
+	}
+	ret := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		ret[k] = v
+	}
+	return ret
+}
+
+// optionString 获取字符串类型的参数，不存在时返回空字符串
+func optionString(options map[string]interface{}, key string) (string, error) {
+	val, ok := options[key]
+	if !ok {
+		return "", nil
+	}
+	str, ok := val.(string)
+	if !ok {
+		return "", ErrorProcessorOptions
+	}
+	return str, nil
+}
+
+// optionStrings 获取字符串列表类型的参数，支持 []string 以及元素都为字符串的 []interface{}
+func optionStrings(options map[string]interface{}, key string) ([]string, error) {
+	switch val := options[key].(type) {
+	case []string:
+		return val, nil
+	case []interface{}:
+		ret := make([]string, 0, len(val))
+		for i := range val {
+			str, ok := val[i].(string)
+			if !ok {
+				return nil, ErrorProcessorOptions
+			}
+			ret = append(ret, str)
+		}
+		return ret, nil
+	case string:
+		return strings.Split(val, ","), nil
+	default:
+		return nil, ErrorProcessorOptions
+	}
+}
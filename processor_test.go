@@ -0,0 +1,125 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProcessorChain(t *testing.T) {
+	fail := errors.New("mock processor fail")
+	chain, err := newProcessorChain([]ProcessorConfig{
+		{Type: "add_fields", Options: map[string]interface{}{
+			"fields": map[string]interface{}{"env": "prod"},
+			"target": "meta",
+		}},
+		{Type: "split", Options: map[string]interface{}{"separator": ";"}},
+		{Processor: ProcessorFunc(func(event *Event) ([]*Event, error) {
+			if event.String() == "bad" {
+				return nil, fail
+			}
+			event.PutField("tmp", 1)
+			return []*Event{event}, nil
+		})},
+		{Type: "drop_fields", Options: map[string]interface{}{"fields": []interface{}{"tmp"}}},
+		{Processor: ProcessorFunc(func(event *Event) ([]*Event, error) {
+			if event.String() == "drop" {
+				return nil, fail
+			}
+			return []*Event{event}, nil
+		}), OnError: ProcessorOnErrorDrop},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []error
+	events := chain.run(&Event{Content: []byte("a;bad;;drop;b")}, func(err error) {
+		errs = append(errs, err)
+	})
+	actual := make([]string, 0, len(events))
+	for i := range events {
+		actual = append(actual, events[i].String())
+		if !reflect.DeepEqual(events[i].Fields, map[string]interface{}{"meta": map[string]interface{}{"env": "prod"}}) {
+			t.Fatalf("unexpect fields : %v", events[i].Fields)
+		}
+	}
+	if strings.Join(actual, ",") != "a,bad,b" || !events[1].HasFlag(FlagProcessorError) || events[0].HasFlag(FlagProcessorError) {
+		t.Fatalf("unexpect events : %v", actual)
+	}
+	if len(errs) != 2 || !errors.Is(errs[0], fail) {
+		t.Fatalf("unexpect errors : %v", errs)
+	}
+
+	if _, err := newProcessorChain([]ProcessorConfig{{Type: "unknown"}}); !errors.Is(err, ErrorUnknownProcessor) {
+		t.Fatalf("expect ErrorUnknownProcessor, actual=%v", err)
+	}
+	if _, err := newProcessorChain([]ProcessorConfig{{Type: "add_fields"}}); !errors.Is(err, ErrorProcessorOptions) {
+		t.Fatalf("expect ErrorProcessorOptions, actual=%v", err)
+	}
+}
+
+func TestHarvester_Processors(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "line=1,line=2", "debug", "line=3", "debug")
+
+	sink := &mockSink{}
+	beater := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, "app\\.log"),
+		MetaPath: filepath.Join(dir, "meta"),
+		Processors: []ProcessorConfig{
+			{Type: "split", Options: map[string]interface{}{"separator": ","}},
+			{Processor: ProcessorFunc(func(event *Event) ([]*Event, error) {
+				if event.String() == "debug" {
+					return nil, nil
+				}
+				return []*Event{event}, nil
+			})},
+		},
+	}).(*harvester)
+	beater.RegisterSink(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	sink.waitFor(t, 3)
+
+	if msgs := sink.messages(); strings.Join(msgs, ",") != "line=1,line=2,line=3" {
+		t.Fatalf("unexpect messages : %v", msgs)
+	}
+	// 被丢弃的最后一行的位点依然会被提交
+	stat, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		state, _ := beater.registry.Get(GetOSState(stat).String())
+		return state.Offset == stat.Size()
+	})
+}
@@ -24,34 +24,55 @@ package filebeat
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"os"
-	"strings"
 	"sync/atomic"
+	"time"
 	"unicode/utf8"
 )
 
-var (
-	delimLabel byte = '\n'
+const (
+	// LongLineTruncate 超长的行截断到 MaxBytes 后继续投递，并标记为 FlagTruncated
+	LongLineTruncate = "truncate"
+	// LongLineSkip 超长的行直接丢弃
+	LongLineSkip = "skip"
+
+	// defaultMaxLineBytes 一行数据默认的最大字节数
+	defaultMaxLineBytes = 10 << 20
+)
 
+var (
 	// ErrorRename 文件被重命名错误
 	ErrorRename error = errors.New("log already rename")
 
 	// ErrorRemoved 文件被移走错误
 	ErrorRemoved error = errors.New("log already removed")
 
+	// ErrorTruncated 文件被截断（例如 logrotate 的 copytruncate），Reader 已经重新从文件头开始读取
+	ErrorTruncated error = errors.New("log already truncated")
+
 	// ErrorEmpty 空数据错误
 	ErrorEmpty error = errors.New("no content")
 
 	// ErrorClosed Reader 已经被关闭了
 	ErrorClosed error = errors.New("reader already closed")
+
+	// ErrorLongLineAction 不支持的 LineReaderConfig.LongLineAction 配置
+	ErrorLongLineAction error = errors.New("long line action must be truncate or skip")
+
+	// utf8BOM 文件开头的 UTF-8 BOM，UTF-16 的 BOM 转换后同样为该内容
+	utf8BOM = []byte("\uFEFF")
+
+	// ErrorFingerprintBytes 不合法的 LineReaderConfig.FingerprintBytes 配置
+	ErrorFingerprintBytes error = errors.New("fingerprint bytes must not be negative")
 )
 
 // Reader is the interface that wraps the basic Next method for
-// getting a new message.
-// Next returns the message being read or and error. EOF is returned
-// if reader will not return any new message on subsequent calls.
+// getting a new event.
+// Next returns the event being read or and error. EOF is returned
+// if reader will not return any new event on subsequent calls.
 type Reader interface {
 	io.Closer
 	// Offset
@@ -61,52 +82,180 @@ type Reader interface {
 	//  @return *os.File
 	CurFile() *os.File
 	// Next
-	//  @return Message
+	//  @return *Event
 	//  @return error
-	Next() (string, error)
+	Next() (*Event, error)
+}
+
+// LineReaderConfig LineReader 的配置
+type LineReaderConfig struct {
+	// MaxBytes 一行数据（不包含换行符）的最大字节数，<= 0 时使用默认值 10MiB
+	MaxBytes int
+	// LongLineAction 超过 MaxBytes 的行的处理方式，truncate 或者 skip，为空时默认为 truncate
+	// 无论哪种方式，位点都会前进到该行的末尾
+	LongLineAction string
+	// Encoding 文件内容的字符编码，支持 utf-8、latin1、utf-16le、utf-16be、gbk、gb18030、big5 以及通过 RegisterEncoding 注册的编码
+	// 为空时默认为 utf-8，Event 的内容会被转换为 UTF-8，位点以及 MaxBytes 依然按照文件中的原始字节计算
+	Encoding string
+	// FingerprintBytes 文件头部指纹的字节数，> 0 时每次读到文件末尾都会比较文件头部的内容
+	// 用于发现文件被截断后又迅速写入了超过原位点的数据的场景，为 0 时只通过文件大小判断是否被截断
+	FingerprintBytes int
+}
+
+func (cfg LineReaderConfig) withDefaults() LineReaderConfig {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultMaxLineBytes
+	}
+	if cfg.LongLineAction == "" {
+		cfg.LongLineAction = LongLineTruncate
+	}
+	return cfg
+}
+
+// validate 检查配置是否合法
+func (cfg LineReaderConfig) validate() error {
+	cfg = cfg.withDefaults()
+	if cfg.LongLineAction != LongLineTruncate && cfg.LongLineAction != LongLineSkip {
+		return ErrorLongLineAction
+	}
+	if cfg.FingerprintBytes < 0 {
+		return ErrorFingerprintBytes
+	}
+	_, err := findEncoding(cfg.Encoding)
+	return err
 }
 
 // LineReader 按行读取的 line-reader 实现
 type LineReader struct {
 	closed     int32
 	originName string
+	cfg        LineReaderConfig
 	curFile    *os.File
+	state      StateOS
 	readOffset *int64
-	reader     *bufio.Scanner
-	lastData   []byte
-	pre        string
+	reader     *bufio.Reader
+	// pre 已经读取到但还没有遇到换行符的数据，超过 MaxBytes 的部分不会被保存
+	// readOffset 只会在读取到完整的一行后才会前进
+	pre []byte
+	// preSize 已经读取到但还没有遇到换行符的数据的实际长度
+	preSize int64
+	// fingerprint 文件头部 FingerprintBytes 个字节的内容，文件长度足够之后才会被记录
+	fingerprint []byte
+	// decompress 压缩文件的解压方式，为 nil 时表示普通文件
+	// 压缩文件的位点为解压后的字节数，并且不会再发生变化，读完之后返回 ErrorFinished
+	decompress Decompressor
+	// decoder 压缩文件解压后的数据流
+	decoder io.ReadCloser
+	// enc 文件内容的字符编码
+	enc *encoding
+	// decode 将一行数据转换为 UTF-8，为 nil 时表示不需要转换
+	decode Decoder
+	// lastByte 最近一次读取到的数据的最后一个字节，用于判断多字节的换行符
+	lastByte byte
 }
 
 // NewLineReader 构造一个 Reader
 func NewLineReader(name string, offset *int64) (Reader, error) {
+	return NewLineReaderWithConfig(name, offset, LineReaderConfig{})
+}
+
+// NewLineReaderWithConfig 根据配置构造一个 Reader
+func NewLineReaderWithConfig(name string, offset *int64, cfg LineReaderConfig) (Reader, error) {
+	return newLineReader(name, offset, cfg, nil)
+}
+
+// NewCompressedLineReader 构造一个读取压缩文件的 Reader，根据文件后缀选择 RegisterDecompressor 注册的解压方式
+// offset 为解压后的字节数，文件读完之后返回 ErrorFinished
+func NewCompressedLineReader(name string, offset *int64, cfg LineReaderConfig) (Reader, error) {
+	decompress, ok := findDecompressor(name)
+	if !ok {
+		return nil, ErrorUnsupportedCompression
+	}
+	return newLineReader(name, offset, cfg, decompress)
+}
+
+func newLineReader(name string, offset *int64, cfg LineReaderConfig, decompress Decompressor) (Reader, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
 	f, err := readOpen(name)
 	if err != nil {
 		return nil, err
 	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	enc, _ := findEncoding(cfg.Encoding)
 
 	r := &LineReader{
 		originName: name,
+		cfg:        cfg.withDefaults(),
 		curFile:    f,
+		state:      GetOSState(stat),
 		readOffset: offset,
-		lastData:   make([]byte, 1),
+		decompress: decompress,
+		enc:        enc,
+	}
+	if enc.newDecoder != nil {
+		r.decode = enc.newDecoder()
 	}
 
 	// 设置文件读取的位置信息数据
-	r.seek()
+	if err := r.seek(); err != nil {
+		// 压缩文件还没有写完，等待下次读取时再重试
+		if decompress == nil || !isIncomplete(err) {
+			f.Close()
+			return nil, err
+		}
+	}
 	return r, nil
 }
 
-func (line *LineReader) seek() {
-	line.curFile.Seek(func() int64 {
-		if *line.readOffset == 0 {
-			return *line.readOffset
-		}
-		return *line.readOffset + 1
-	}(), io.SeekStart)
+func (line *LineReader) seek() error {
+	if line.decompress != nil {
+		return line.seekCompressed()
+	}
+	// readOffset 指向下一个待读取的字节
+	if _, err := line.curFile.Seek(*line.readOffset, io.SeekStart); err != nil {
+		return err
+	}
+	line.reader = bufio.NewReader(line.curFile)
+	line.pre = line.pre[:0]
+	line.preSize = 0
+	return nil
+}
 
-	scanner := bufio.NewScanner(line.curFile)
-	scanner.Split(bufio.ScanLines)
-	line.reader = scanner
+// seekCompressed 压缩文件无法直接定位，从头开始解压并跳过 readOffset 个字节
+func (line *LineReader) seekCompressed() error {
+	line.resetDecoder()
+	if _, err := line.curFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	decoder, err := line.decompress(line.curFile)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, decoder, *line.readOffset); err != nil {
+		decoder.Close()
+		return err
+	}
+	line.decoder = decoder
+	line.reader = bufio.NewReader(decoder)
+	return nil
+}
+
+// resetDecoder 丢弃当前的解压数据流以及还没有读完的行，下次读取时重新开始解压
+func (line *LineReader) resetDecoder() {
+	if line.decoder != nil {
+		line.decoder.Close()
+		line.decoder = nil
+	}
+	line.reader = nil
+	line.pre = line.pre[:0]
+	line.preSize = 0
 }
 
 // CurFile
@@ -122,75 +271,255 @@ func (line *LineReader) Offset() int64 {
 // Close
 func (line *LineReader) Close() error {
 	atomic.StoreInt32(&line.closed, 1)
-	line.reader = nil
+	line.resetDecoder()
 	return line.curFile.Close()
 }
 
-var ()
-
 // Next
-func (line *LineReader) Next() (string, error) {
+func (line *LineReader) Next() (*Event, error) {
 
 	if atomic.LoadInt32(&line.closed) == 1 {
-		return "", ErrorClosed
+		return nil, ErrorClosed
+	}
+	if line.decompress != nil {
+		return line.nextCompressed()
+	}
+
+	event, err := line.readLine()
+	if event != nil || !errors.Is(err, io.EOF) {
+		return event, err
+	}
+
+	// 读完当前文件了，判断当前文件是否已经被切走
+	stat, err := os.Stat(line.originName)
+	if err != nil {
+		// 如果当前文件找不到，肯定是文件不一样了
+		// 文件没有被删除时，说明被重命名后原路径下还没有创建新的文件
+		if errors.Is(err, os.ErrNotExist) {
+			if isRemoved(line.curFile) {
+				return nil, ErrorRemoved
+			}
+			return nil, ErrorRename
+		}
+		return nil, err
 	}
 
-	if line.reader.Scan() {
-		var (
-			ret string
-			err error
-		)
+	// 当前文件已经被删除
+	if isRemoved(line.curFile) {
+		return nil, ErrorRemoved
+	}
 
-		msg := line.reader.Text()
-		_, err = line.curFile.ReadAt(line.lastData, *line.readOffset+int64(len(msg))+1)
+	// 已经不是同一个日志文件了，并且当前文件已经读完，准备读取新的日志文件
+	cur, err := line.curFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !os.SameFile(cur, stat) {
+		return nil, ErrorRename
+	}
 
-		// 需要去掉 '\n'
-		// ReadSlice 会把分隔符也一并带上，这里是有问题的，需要单独进行处理把分隔符清理掉
-		res := strings.Split(string(msg), string(delimLabel))
-		(*line.readOffset) += int64(len(msg))
+	// 同一个文件变小了或者头部的内容发生了变化，说明文件被截断过，从头开始重新读取
+	truncated, err := line.isTruncated(cur)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		*line.readOffset = 0
+		line.fingerprint = nil
+		if err := line.seek(); err != nil {
+			return nil, err
+		}
+		return nil, ErrorTruncated
+	}
+	return nil, io.EOF
+}
 
-		switchLineRune := '\n'
+// isTruncated 判断文件是否被截断，copytruncate 方式的日志轮转不会改变文件的 I-Node 信息
+func (line *LineReader) isTruncated(cur os.FileInfo) (bool, error) {
+	consumed := *line.readOffset + line.preSize
+	if cur.Size() < consumed {
+		return true, nil
+	}
 
-		lastRune, _ := utf8.DecodeRune(line.lastData)
+	size := line.cfg.FingerprintBytes
+	if size <= 0 || consumed < int64(size) {
+		return false, nil
+	}
+	head := make([]byte, size)
+	if _, err := line.curFile.ReadAt(head, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		return false, err
+	}
+	if line.fingerprint == nil {
+		line.fingerprint = head
+		return false, nil
+	}
+	return !bytes.Equal(line.fingerprint, head), nil
+}
 
-		if lastRune == switchLineRune {
-			*line.readOffset += 1
-			ret = line.pre + res[0]
-			line.lastData[0] = byte(0)
-			line.pre = ""
-		} else {
-			line.pre += res[0]
-			ret = ""
-			err = io.EOF
+// readLine 读取完整的一行数据，没有完整的一行时返回 io.EOF
+func (line *LineReader) readLine() (*Event, error) {
+	for {
+		data, err := line.reader.ReadSlice(line.enc.delim())
+		prev := line.lastByte
+		if len(data) >= 2 {
+			prev = data[len(data)-2]
+		}
+		line.appendPre(data)
+		if err == nil {
+			if !line.isLineEnd(prev) {
+				// 只是多字节字符中的一部分，继续查找真正的换行符
+				continue
+			}
+			if event := line.newEvent(); event != nil {
+				return event, nil
+			}
+			// 超长的行被丢弃了，继续读取下一行
+			continue
 		}
-		return ret, err
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// 一行数据超过了 bufio.Reader 的缓冲区大小，继续读取剩余的部分，超过 MaxBytes 的部分不会被保存
+			continue
+		}
+		return nil, err
 	}
+}
 
-	err := line.reader.Err()
-	if err == nil {
-		err = io.EOF
+// isLineEnd 判断以换行符最后一个字节结尾的数据是否是完整的一行，prev 为该字节之前的一个字节
+func (line *LineReader) isLineEnd(prev byte) bool {
+	enc := line.enc
+	if enc.unit == 1 {
+		return true
 	}
-	if errors.Is(err, io.EOF) {
-		f, err := readOpen(line.originName)
-		if err != nil {
-			// 如果当前文件找不到，肯定是文件不一样了
-			if errors.Is(err, os.ErrNotExist) {
-				return "", ErrorRemoved
+	// 换行符需要按照编码单元对齐，例如 UTF-16LE 中 0x0A 也可能是其他字符的高位字节
+	return line.preSize%int64(enc.unit) == 0 && prev == enc.newline[0]
+}
+
+// nextCompressed 读取压缩文件中的下一行数据
+func (line *LineReader) nextCompressed() (*Event, error) {
+	if line.reader == nil {
+		if err := line.seek(); err != nil {
+			if isIncomplete(err) {
+				line.resetDecoder()
+				return nil, io.EOF
 			}
-			return "", err
+			return nil, err
 		}
+	}
 
-		// 当前文件已经被删除
-		if isRemoved(line.curFile) {
-			return "", ErrorRemoved
+	event, err := line.readLine()
+	if err == nil {
+		return event, nil
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// 压缩文件还没有写完，下次读取时重新解压并跳过已经读取的部分
+		line.resetDecoder()
+		return nil, io.EOF
+	}
+	if !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	// 压缩文件不会再发生变化，最后一行即使没有换行符也是完整的一行
+	if line.preSize > 0 {
+		if event := line.newEvent(); event != nil {
+			return event, nil
 		}
+	}
+	return nil, ErrorFinished
+}
+
+// appendPre 记录读取到的数据，超过 MaxBytes 的部分只记录长度，不保存内容
+func (line *LineReader) appendPre(data []byte) {
+	line.preSize += int64(len(data))
+	if len(data) > 0 {
+		line.lastByte = data[len(data)-1]
+	}
+	// 额外保留用于存放行尾的 \r\n 的空间
+	room := line.cfg.MaxBytes + len(line.enc.cr) + len(line.enc.newline) - len(line.pre)
+	if room <= 0 {
+		return
+	}
+	if len(data) > room {
+		data = data[:room]
+	}
+	line.pre = append(line.pre, data...)
+}
+
+// newEvent 将 pre 中完整的一行数据构造为 Event，并推进 readOffset
+// 如果该行超过了 MaxBytes 并且配置为 skip，则只推进 readOffset，返回 nil
+func (line *LineReader) newEvent() *Event {
+	start := *line.readOffset
+	end := start + line.preSize
+
+	content := line.pre
+	if int64(len(line.pre)) == line.preSize {
+		content = bytes.TrimSuffix(content, line.enc.newline)
+		content = bytes.TrimSuffix(content, line.enc.cr)
+	}
+	truncated := len(content) > line.cfg.MaxBytes || int64(len(line.pre)) != line.preSize
+
+	*line.readOffset = end
+	line.pre = line.pre[:0]
+	line.preSize = 0
+	line.lastByte = 0
+
+	if truncated && line.cfg.LongLineAction == LongLineSkip {
+		return nil
+	}
 
-		// 已经不是同一个日志文件了，并且当前文件已经读完，准备读取新的日志文件
-		if !isSameFile(line.curFile, f) {
-			return "", ErrorRename
+	decodeFail := false
+	if line.decode != nil {
+		if truncated {
+			// 按照编码单元截断，避免产生多余的 utf8.RuneError
+			content = content[:line.cfg.MaxBytes/line.enc.unit*line.enc.unit]
 		}
-		line.curFile = f
-		line.seek()
+		decoded, err := line.decode(content)
+		if err != nil {
+			decodeFail = true
+			content = append([]byte(nil), content...)
+		} else {
+			content = decoded
+		}
+	} else {
+		content = append([]byte(nil), content...)
+	}
+	if start == 0 {
+		content = bytes.TrimPrefix(content, utf8BOM)
+	}
+	if truncated {
+		content = truncateUTF8(content, line.cfg.MaxBytes)
+	}
+
+	event := &Event{
+		Content:   content,
+		Source:    line.originName,
+		State:     line.state,
+		Offset:    start,
+		EndOffset: end,
+		ReadTime:  time.Now(),
+	}
+	if truncated {
+		event.AddFlag(FlagTruncated)
+	}
+	if decodeFail {
+		event.AddFlag(FlagEncodingError)
+	}
+	return event
+}
+
+// truncateUTF8 将数据截断到不超过 max 个字节，并且尽量不截断在一个字符的中间
+func truncateUTF8(data []byte, max int) []byte {
+	if len(data) <= max {
+		return data
+	}
+	pos := max
+	for pos > 0 && pos > max-utf8.UTFMax && !utf8.RuneStart(data[pos]) {
+		pos--
+	}
+	if pos == 0 || !utf8.RuneStart(data[pos]) {
+		pos = max
 	}
-	return "", err
+	return data[:pos]
 }
@@ -23,8 +23,13 @@
 package filebeat_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"errors"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -55,19 +60,460 @@ func Test_ReaderLine(t *testing.T) {
 
 	index := 0
 	for {
-		msg, err := reader.Next()
+		event, err := reader.Next()
 		if err != nil {
 			if errors.Is(err, filebeat.ErrorRemoved) || errors.Is(err, filebeat.ErrorClosed) || errors.Is(err, io.EOF) {
 				t.Log(err)
+				if index != len(expectLines) {
+					t.Fatalf("expect read %d lines, actual=%d", len(expectLines), index)
+				}
 				return
 			}
 			t.Fatal(err)
 		}
 
-		if strings.Compare(msg, expectLines[index]) != 0 {
-			t.Fatalf("no equal, expect=[%s], acutal=[%s]", expectLines[index], msg)
+		if strings.Compare(event.String(), expectLines[index]) != 0 {
+			t.Fatalf("no equal, expect=[%s], acutal=[%s]", expectLines[index], event.String())
+		}
+		if event.EndOffset != offset || event.EndOffset-event.Offset != int64(len(expectLines[index])+1) {
+			t.Fatalf("unexpect offset, start=%d, end=%d, reader=%d", event.Offset, event.EndOffset, offset)
 		}
 
 		index++
 	}
 }
+
+func Test_ReaderPartialLine(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "partial.log")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	offset := int64(0)
+	reader, err := filebeat.NewLineReader(name, &offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// 没有换行符的数据不会被当作完整的一行，位点也不会前进
+	if _, err := f.WriteString("test_line"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expect EOF, actual=%v", err)
+	}
+	if offset != 0 {
+		t.Fatalf("offset should not move forward, actual=%d", offset)
+	}
+
+	if _, err := f.WriteString("_log=1\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	event, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.String() != "test_line_log=1" || event.Offset != 0 || event.EndOffset != 17 || offset != 17 {
+		t.Fatalf("unexpect event : %s, start=%d, end=%d", event.String(), event.Offset, event.EndOffset)
+	}
+	if event.Source != name {
+		t.Fatalf("unexpect source : %s", event.Source)
+	}
+}
+
+func Test_ReaderLongLine(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "long.log")
+	content := strings.Repeat("a", 100) + "\n" + "short\n" + strings.Repeat("b", 5000) + "\n" + "end\n"
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	offset := int64(0)
+	reader, err := filebeat.NewLineReaderWithConfig(name, &offset, filebeat.LineReaderConfig{
+		MaxBytes: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	expect := []string{strings.Repeat("a", 10), "short", strings.Repeat("b", 10), "end"}
+	for i := range expect {
+		event, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.String() != expect[i] {
+			t.Fatalf("no equal, expect=[%s], acutal=[%s]", expect[i], event.String())
+		}
+		if truncated := event.HasFlag(filebeat.FlagTruncated); truncated != (i%2 == 0) {
+			t.Fatalf("unexpect truncated flag for line %d : %v", i, truncated)
+		}
+	}
+	if offset != int64(len(content)) {
+		t.Fatalf("offset should move past all lines, expect=%d, actual=%d", len(content), offset)
+	}
+
+	// skip 模式下超长的行直接被丢弃，位点依然会前进
+	offset = 0
+	reader, err = filebeat.NewLineReaderWithConfig(name, &offset, filebeat.LineReaderConfig{
+		MaxBytes:       10,
+		LongLineAction: filebeat.LongLineSkip,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	for _, line := range []string{"short", "end"} {
+		event, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.String() != line {
+			t.Fatalf("no equal, expect=[%s], acutal=[%s]", line, event.String())
+		}
+	}
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) || offset != int64(len(content)) {
+		t.Fatalf("expect EOF at the end of file, err=%v, offset=%d", err, offset)
+	}
+}
+
+func Test_ReaderTruncate(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "truncate.log")
+	if err := ioutil.WriteFile(name, []byte("test_line_log=1\ntest_line_log=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	offset := int64(0)
+	reader, err := filebeat.NewLineReader(name, &offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expect EOF, actual=%v", err)
+	}
+
+	// copytruncate：文件的 I-Node 不变，但是大小小于当前的位点
+	if err := ioutil.WriteFile(name, []byte("new_line=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); !errors.Is(err, filebeat.ErrorTruncated) {
+		t.Fatalf("expect ErrorTruncated, actual=%v", err)
+	}
+	if offset != 0 {
+		t.Fatalf("offset should be reset, actual=%d", offset)
+	}
+	event, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.String() != "new_line=1" || event.Offset != 0 {
+		t.Fatalf("unexpect event : %s, start=%d", event.String(), event.Offset)
+	}
+}
+
+func Test_ReaderTruncateFingerprint(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "truncate.log")
+	if err := ioutil.WriteFile(name, []byte("test_line_log=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	offset := int64(0)
+	reader, err := filebeat.NewLineReaderWithConfig(name, &offset, filebeat.LineReaderConfig{
+		FingerprintBytes: 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expect EOF, actual=%v", err)
+	}
+
+	// 文件被截断后又迅速写入了超过原位点的数据，只能通过头部指纹发现
+	if err := ioutil.WriteFile(name, []byte("rotated_line=1\nrotated_line=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err := reader.Next()
+		if errors.Is(err, filebeat.ErrorTruncated) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expect ErrorTruncated, actual=%v", err)
+		}
+	}
+	event, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.String() != "rotated_line=1" {
+		t.Fatalf("unexpect event : %s", event.String())
+	}
+}
+
+func Test_ReaderCompressed(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte("gz_line=1\ngz_line=2\ngz_line=3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content := buf.Bytes()
+
+	// 压缩文件还没有写完时，等待后续的数据
+	name := filepath.Join(t.TempDir(), "app.log.1.gz")
+	if err := ioutil.WriteFile(name, content[:len(content)-8], 0644); err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(0)
+	reader, err := filebeat.NewCompressedLineReader(name, &offset, filebeat.LineReaderConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	actual := make([]string, 0)
+	for {
+		event, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, event.String())
+	}
+
+	if err := ioutil.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		event, err := reader.Next()
+		if errors.Is(err, filebeat.ErrorFinished) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, event.String())
+	}
+	// 位点为解压后的字节数，最后一行即使没有换行符也是完整的一行
+	if strings.Join(actual, ",") != "gz_line=1,gz_line=2,gz_line=3" || offset != 29 {
+		t.Fatalf("unexpect lines : %v, offset=%d", actual, offset)
+	}
+
+	// 从记录的位点继续读取
+	offset = 10
+	reader, err = filebeat.NewCompressedLineReader(name, &offset, filebeat.LineReaderConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	event, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.String() != "gz_line=2" || event.Offset != 10 {
+		t.Fatalf("unexpect event : %s, start=%d", event.String(), event.Offset)
+	}
+
+	if _, err := filebeat.NewCompressedLineReader("app.log.1.bz2", &offset, filebeat.LineReaderConfig{}); !errors.Is(err, filebeat.ErrorUnsupportedCompression) {
+		t.Fatalf("expect ErrorUnsupportedCompression, actual=%v", err)
+	}
+}
+
+func Test_ReaderEncoding(t *testing.T) {
+	dir := t.TempDir()
+	utf16le := func(s string, bom bool) []byte {
+		ret := make([]byte, 0)
+		if bom {
+			ret = append(ret, 0xFF, 0xFE)
+		}
+		for _, r := range s {
+			ret = append(ret, byte(r), byte(r>>8))
+		}
+		return ret
+	}
+	utf16be := func(s string) []byte {
+		ret := make([]byte, 0)
+		for _, r := range s {
+			ret = append(ret, byte(r>>8), byte(r))
+		}
+		return ret
+	}
+
+	tests := []struct {
+		encoding string
+		content  []byte
+		expect   []string
+	}{
+		// Ċ(U+010A) 的低位字节为 0x0A，不能被当作换行符
+		{filebeat.EncodingUTF16LE, utf16le("line=Ċ1\r\nline=2\n", true), []string{"line=Ċ1", "line=2"}},
+		{filebeat.EncodingUTF16BE, utf16be("line=Ċ1\r\nline=2\n"), []string{"line=Ċ1", "line=2"}},
+		{filebeat.EncodingLatin1, []byte("caf\xe9\nna\xefve\n"), []string{"café", "naïve"}},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(dir, tt.encoding+".log")
+		if err := ioutil.WriteFile(name, tt.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		offset := int64(0)
+		reader, err := filebeat.NewLineReaderWithConfig(name, &offset, filebeat.LineReaderConfig{
+			Encoding: tt.encoding,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+
+		for i := range tt.expect {
+			event, err := reader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.String() != tt.expect[i] {
+				t.Fatalf("%s expect=[%s], actual=[%s]", tt.encoding, tt.expect[i], event.String())
+			}
+		}
+		// 位点按照文件中的原始字节计算
+		if _, err := reader.Next(); !errors.Is(err, io.EOF) || offset != int64(len(tt.content)) {
+			t.Fatalf("%s expect EOF at the end of file, err=%v, offset=%d", tt.encoding, err, offset)
+		}
+	}
+
+	// 通过 RegisterEncoding 注册其他的编码
+	filebeat.RegisterEncoding("test-upper", func() filebeat.Decoder {
+		return func(raw []byte) ([]byte, error) {
+			return bytes.ToUpper(raw), nil
+		}
+	})
+	name := filepath.Join(dir, "upper.log")
+	if err := ioutil.WriteFile(name, []byte("line=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(0)
+	reader, err := filebeat.NewLineReaderWithConfig(name, &offset, filebeat.LineReaderConfig{
+		Encoding: "TEST-UPPER",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if event, err := reader.Next(); err != nil || event.String() != "LINE=1" {
+		t.Fatalf("unexpect event : %v, %v", event, err)
+	}
+
+	if _, err := filebeat.NewLineReaderWithConfig(name, &offset, filebeat.LineReaderConfig{
+		Encoding: "unknown",
+	}); !errors.Is(err, filebeat.ErrorUnsupportedEncoding) {
+		t.Fatalf("expect ErrorUnsupportedEncoding, actual=%v", err)
+	}
+}
+
+func Test_ReaderChineseEncoding(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		encoding string
+		first    []byte
+		second   []byte
+		expect   []string
+	}{
+		{filebeat.EncodingGBK, []byte("\xd6\xd0\xce\xc4=1\r\n"), []byte("\xd6\xd0\xce\xc4=2\n"), []string{"中文=1", "中文=2"}},
+		// GB18030 中的 4 字节字符
+		{filebeat.EncodingGB18030, []byte("\xd6\xd0\xce\xc4\x94\x39\xfc\x36\n"), []byte("\xd6\xd0\xce\xc4=2\n"), []string{"中文😀", "中文=2"}},
+		// 許 的第二个字节为 0x5C（\）
+		{filebeat.EncodingBig5, []byte("\xb3\x5c\xa5\x69=1\n"), []byte("\xb3\x5c\xa5\x69=2\n"), []string{"許可=1", "許可=2"}},
+		// GB18030 可以表示 U+FFFD 本身，不属于无法转换的内容
+		{filebeat.EncodingGB18030, []byte("\x84\x31\xa4\x37=1\n"), []byte("\xd6\xd0\xce\xc4=2\n"), []string{"\ufffd=1", "中文=2"}},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(dir, tt.encoding+".log")
+		content := append(append([]byte{}, tt.first...), tt.second...)
+		if err := ioutil.WriteFile(name, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := filebeat.LineReaderConfig{Encoding: tt.encoding}
+
+		offset := int64(0)
+		reader, err := filebeat.NewLineReaderWithConfig(name, &offset, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event, err := reader.Next()
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 位点按照文件中的原始字节计算
+		if event.String() != tt.expect[0] || event.HasFlag(filebeat.FlagEncodingError) || event.EndOffset != int64(len(tt.first)) {
+			t.Fatalf("%s expect=[%s] end=%d, actual=[%s] end=%d", tt.encoding, tt.expect[0], len(tt.first), event.String(), event.EndOffset)
+		}
+
+		// 从第一行的结束位点继续读取
+		offset = event.EndOffset
+		reader, err = filebeat.NewLineReaderWithConfig(name, &offset, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event, err = reader.Next()
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.String() != tt.expect[1] || event.Offset != int64(len(tt.first)) || event.EndOffset != int64(len(content)) {
+			t.Fatalf("%s expect=[%s], actual=[%s] %d-%d", tt.encoding, tt.expect[1], event.String(), event.Offset, event.EndOffset)
+		}
+	}
+}
+
+func Test_ReaderChineseEncodingError(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		encoding string
+		content  []byte
+	}{
+		// 行尾不完整的双字节字符
+		{filebeat.EncodingGBK, []byte("\xd6\xd0\x81")},
+		{filebeat.EncodingGBK, []byte("\xd6\xd0\xff\xce\xc4")},
+		{filebeat.EncodingGB18030, []byte("\xd6\xd0\x84\x31")},
+		{filebeat.EncodingBig5, []byte("\xb3\x5c\x80")},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(dir, tt.encoding+".log")
+		if err := ioutil.WriteFile(name, append(append([]byte{}, tt.content...), '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		offset := int64(0)
+		reader, err := filebeat.NewLineReaderWithConfig(name, &offset, filebeat.LineReaderConfig{Encoding: tt.encoding})
+		if err != nil {
+			t.Fatal(err)
+		}
+		event, err := reader.Next()
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 无法转换时保留原始内容，并标记为 FlagEncodingError
+		if !event.HasFlag(filebeat.FlagEncodingError) || string(event.Content) != string(tt.content) {
+			t.Fatalf("%s expect encoding error with raw content, actual=[%q] flags=%v", tt.encoding, event.String(), event.Flags)
+		}
+	}
+}
@@ -22,8 +22,95 @@
 
 package filebeat
 
+import (
+	"sync/atomic"
+)
+
 // Sink handle log each line
 type Sink interface {
 	// OnMessage
 	OnMessage(msg string)
 }
+
+// AckFunc 确认数据已经被 Sink 处理完成，可以在任意协程中调用，重复调用只会生效一次
+type AckFunc func()
+
+// AckSink handle log each event, and acknowledge it after the event is really handled
+//
+// OnEvent 返回 error 表示处理失败，harvester 会在等待一段时间后重新投递该行数据；
+// 返回 nil 后，需要在数据真正处理完成（例如已经发送到远端）之后调用 ack 进行确认，
+// 可以先缓存多行数据再统一异步确认。只有被所有 Sink 连续确认的数据，其位点才会被持久化，
+// 因此在 Sink 确认之前重启，这部分数据会被重新投递
+type AckSink interface {
+	// OnEvent
+	OnEvent(event *Event, ack AckFunc) error
+}
+
+// BatchSink handle a batch of events
+//
+// harvester 会按照 BatchConfig 将多条数据攒成一批再投递。OnBatch 返回 error 表示整批处理失败，
+// harvester 会重新投递整批数据；返回 nil 后，需要在整批数据处理完成之后调用 ack 进行确认
+type BatchSink interface {
+	// OnBatch
+	OnBatch(events []*Event, ack AckFunc) error
+}
+
+// AdaptSink 将 Sink 适配为 BatchSink，每条数据调用一次 OnMessage，全部返回即表示确认
+//
+//	@param sink
+//	@return BatchSink
+func AdaptSink(sink Sink) BatchSink {
+	return &sinkAdapter{sink: sink}
+}
+
+// AdaptAckSink 将 AckSink 适配为 BatchSink，整批数据中的每一条都被确认后才会确认整批数据
+// 如果其中某一条处理失败，整批数据会被重新投递，之前已经处理成功的数据会被重复投递
+//
+//	@param sink
+//	@return BatchSink
+func AdaptAckSink(sink AckSink) BatchSink {
+	return &ackSinkAdapter{sink: sink}
+}
+
+// sinkAdapter 将 Sink 适配为 BatchSink
+type sinkAdapter struct {
+	sink Sink
+}
+
+// OnBatch
+func (s *sinkAdapter) OnBatch(events []*Event, ack AckFunc) error {
+	for i := range events {
+		s.sink.OnMessage(events[i].String())
+	}
+	ack()
+	return nil
+}
+
+// ackSinkAdapter 将 AckSink 适配为 BatchSink
+type ackSinkAdapter struct {
+	sink AckSink
+}
+
+// OnBatch
+func (s *ackSinkAdapter) OnBatch(events []*Event, ack AckFunc) error {
+	remain := int32(len(events))
+	if remain == 0 {
+		ack()
+		return nil
+	}
+	for i := range events {
+		var acked int32
+		err := s.sink.OnEvent(events[i], func() {
+			if !atomic.CompareAndSwapInt32(&acked, 0, 1) {
+				return
+			}
+			if atomic.AddInt32(&remain, -1) == 0 {
+				ack()
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+)
+
+const (
+	// StartBeginning 从文件头开始采集
+	StartBeginning = "beginning"
+	// StartEnd 从文件末尾开始采集，只采集新写入的数据
+	StartEnd = "end"
+	// StartTimestamp 从第一行时间不早于 StartConfig.Time 的数据开始采集
+	StartTimestamp = "timestamp"
+
+	// defaultStartLayout 行首时间默认的格式
+	defaultStartLayout = "2006-01-02 15:04:05"
+	// maxTimePrefixExtra 行首的时间最多比时间格式长多少字节，例如 September 与 Jan、纳秒的小数部分
+	maxTimePrefixExtra = 32
+	// tailChunkSize 从文件末尾向前查找换行符时每次读取的字节数
+	tailChunkSize = 4096
+	// timestampScanSize 二分查找将范围缩小到该字节数之后，再按行顺序查找
+	timestampScanSize = 64 * 1024
+)
+
+var (
+	// ErrorStartPosition 不支持的 StartConfig.Position 配置
+	ErrorStartPosition error = errors.New("start position must be beginning, end or timestamp")
+
+	// ErrorStartTime StartConfig.Position 为 timestamp 时没有设置 StartConfig.Time
+	ErrorStartTime error = errors.New("start time must be set when start position is timestamp")
+)
+
+// StartConfig 首次发现的文件（Registry 中没有记录）从哪里开始采集
+// 只对启动后第一次扫描发现的文件生效，之后新创建的文件总是从文件头开始采集，避免丢失轮转后新文件的数据
+type StartConfig struct {
+	// Position beginning、end 或者 timestamp，为空时默认为 beginning
+	Position string
+	// Time Position 为 timestamp 时，从第一行时间不早于 Time 的数据开始采集，没有这样的行时从文件末尾开始采集
+	Time time.Time
+	// Layout 行中时间的格式（Go 的时间格式），为空时默认为 2006-01-02 15:04:05，不包含时区时按照 Time 的时区解析
+	Layout string
+	// Pattern 从行中提取时间的正则，有子匹配时使用第一个子匹配，为空时解析行首的时间
+	// 无法解析出时间的行（例如异常堆栈）会被跳过
+	Pattern string
+}
+
+func (cfg StartConfig) withDefaults() StartConfig {
+	if cfg.Position == "" {
+		cfg.Position = StartBeginning
+	}
+	if cfg.Layout == "" {
+		cfg.Layout = defaultStartLayout
+	}
+	return cfg
+}
+
+// validate 检查配置是否合法
+func (cfg StartConfig) validate() error {
+	cfg = cfg.withDefaults()
+	switch cfg.Position {
+	case StartBeginning, StartEnd:
+		return nil
+	case StartTimestamp:
+		if cfg.Time.IsZero() {
+			return ErrorStartTime
+		}
+		_, err := regexp.Compile(cfg.Pattern)
+		return err
+	default:
+		return ErrorStartPosition
+	}
+}
+
+// startOffset 根据 Config.Start 计算首次发现的文件开始采集的位点
+//
+//	@receiver beater
+//	@param item
+//	@return int64
+//	@return error
+func (beater *harvester) startOffset(item fileInfo) (int64, error) {
+	switch beater.cfg.Start.Position {
+	case StartEnd:
+		enc, err := findEncoding(beater.encodingFor(item.path))
+		if err != nil {
+			return 0, err
+		}
+		return tailOffset(item.path, item.Size(), enc)
+	case StartTimestamp:
+		return beater.timestampOffset(item)
+	default:
+		return 0, nil
+	}
+}
+
+// tailOffset 文件末尾完整的行之后的位点，末尾还没有写完的行依然会被采集
+//
+//	@param path
+//	@param size
+//	@param enc
+//	@return int64
+//	@return error
+func tailOffset(path string, size int64, enc *encoding) (int64, error) {
+	f, err := readOpen(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, tailChunkSize)
+	end := size
+	for end > 0 {
+		start := end - tailChunkSize
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		for i := len(chunk) - len(enc.newline); i >= 0; i-- {
+			// 换行符需要按照编码单元对齐
+			if (start+int64(i))%int64(enc.unit) == 0 && bytes.HasPrefix(chunk[i:], enc.newline) {
+				return start + int64(i+len(enc.newline)), nil
+			}
+		}
+		if start == 0 {
+			break
+		}
+		// 换行符可能跨越了两次读取的边界
+		end = start + int64(len(enc.newline)-1)
+	}
+	return 0, nil
+}
+
+// timestampOffset 查找第一行时间不早于 StartConfig.Time 的数据的位点
+// 日志中的时间是单调递增的，先在行边界上二分查找缩小范围，避免在扫描协程中顺序读取整个大文件
+// 压缩文件无法随机读取，只能顺序查找
+//
+//	@receiver beater
+//	@param item
+//	@return int64
+//	@return error
+func (beater *harvester) timestampOffset(item fileInfo) (int64, error) {
+	cfg := beater.cfg.Start
+	var pattern *regexp.Regexp
+	if cfg.Pattern != "" {
+		pattern = regexp.MustCompile(cfg.Pattern)
+	}
+
+	// lo 之前的行都早于 StartConfig.Time
+	lo, hi := int64(0), item.Size()
+	if !beater.isCompressed(item.path) {
+		enc, err := findEncoding(beater.encodingFor(item.path))
+		if err != nil {
+			return 0, err
+		}
+		for hi-lo > timestampScanSize {
+			mid := lo + (hi-lo)/2
+			// 位点需要按照编码单元对齐
+			mid -= mid % int64(enc.unit)
+			end, ts, ok, err := beater.probeLineTime(item.path, mid, hi, pattern)
+			if err != nil {
+				return 0, err
+			}
+			if ok && ts.Before(cfg.Time) {
+				lo = end
+			} else {
+				hi = mid
+			}
+		}
+	}
+
+	offset := lo
+	reader, err := beater.newLineReader(item.path, &offset)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	for {
+		event, err := reader.Next()
+		if err != nil {
+			// 没有满足条件的行，从已经读完的位置开始采集
+			if errors.Is(err, io.EOF) || errors.Is(err, ErrorFinished) || errors.Is(err, ErrorRemoved) || errors.Is(err, ErrorRename) {
+				return offset, nil
+			}
+			if errors.Is(err, ErrorTruncated) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		ts, ok := parseLineTime(event.Content, pattern, cfg.Layout, cfg.Time.Location())
+		if ok && !ts.Before(cfg.Time) {
+			return event.Offset, nil
+		}
+	}
+}
+
+// probeLineTime 从 offset 之后第一个完整的行开始，查找在 limit 之前开始的第一行能够解析出时间的数据
+//
+//	@receiver beater
+//	@param path
+//	@param offset
+//	@param limit
+//	@param pattern
+//	@return int64 该行的结束位点
+//	@return time.Time 该行的时间
+//	@return bool 是否找到了这样的行
+//	@return error
+func (beater *harvester) probeLineTime(path string, offset, limit int64, pattern *regexp.Regexp) (int64, time.Time, bool, error) {
+	cfg := beater.cfg.Start
+	reader, err := beater.newLineReader(path, &offset)
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+	defer reader.Close()
+
+	// offset 可能位于一行的中间，跳过第一行不完整的数据
+	skip := offset > 0
+	for {
+		event, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, ErrorRemoved) || errors.Is(err, ErrorRename) || errors.Is(err, ErrorTruncated) {
+				return 0, time.Time{}, false, nil
+			}
+			return 0, time.Time{}, false, err
+		}
+		if skip {
+			skip = false
+			continue
+		}
+		if event.Offset >= limit {
+			return 0, time.Time{}, false, nil
+		}
+		if ts, ok := parseLineTime(event.Content, pattern, cfg.Layout, cfg.Time.Location()); ok {
+			return event.EndOffset, ts, true, nil
+		}
+	}
+}
+
+// parseLineTime 从一行数据中解析时间
+func parseLineTime(line []byte, pattern *regexp.Regexp, layout string, loc *time.Location) (time.Time, bool) {
+	if pattern == nil {
+		return parseTimePrefix(line, layout, loc)
+	}
+	var value []byte
+	match := pattern.FindSubmatch(line)
+	switch {
+	case len(match) > 1:
+		value = match[1]
+	case len(match) == 1:
+		value = match[0]
+	default:
+		return time.Time{}, false
+	}
+	ts, err := time.ParseInLocation(layout, string(value), loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+// parseTimePrefix 解析行首的时间
+// 时间的长度可能与时间格式不同，例如 RFC3339 中的 Z、_2、January、MST 以及秒的小数部分，
+// 因此由短到长依次尝试在分隔符处结束的内容，都失败时再尝试与时间格式等长的内容
+//
+//	@param line
+//	@param layout
+//	@param loc
+//	@return time.Time
+//	@return bool
+func parseTimePrefix(line []byte, layout string, loc *time.Location) (time.Time, bool) {
+	limit := len(layout) + maxTimePrefixExtra
+	if limit > len(line) {
+		limit = len(line)
+	}
+	for end := 1; end <= limit; end++ {
+		if end < len(line) && !isTimeBoundary(line[end]) {
+			continue
+		}
+		if ts, err := time.ParseInLocation(layout, string(line[:end]), loc); err == nil {
+			return ts, true
+		}
+	}
+	// 时间之后直接跟着其他内容，例如 2022-05-01 10:00:00[main]
+	if len(line) > len(layout) {
+		if ts, err := time.ParseInLocation(layout, string(line[:len(layout)]), loc); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isTimeBoundary 行首的时间之后可能出现的分隔符
+func isTimeBoundary(c byte) bool {
+	switch c {
+	case ' ', '\t', ',', ';', '|', ']', ')':
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,232 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTailOffset(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		content string
+		expect  int64
+	}{
+		{"", 0},
+		{"line=1\nline=2\n", 14},
+		// 末尾还没有写完的行依然需要被采集
+		{"line=1\nline=2", 7},
+		{"partial", 0},
+		{strings.Repeat("a", tailChunkSize*2) + "\n" + strings.Repeat("b", tailChunkSize+10), tailChunkSize*2 + 1},
+	}
+	for i, tt := range tests {
+		name := filepath.Join(dir, "app.log")
+		if err := ioutil.WriteFile(name, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		offset, err := tailOffset(name, int64(len(tt.content)), encodings[EncodingUTF8])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != tt.expect {
+			t.Errorf("case %d expect=%d actual=%d", i, tt.expect, offset)
+		}
+	}
+}
+
+func TestTailOffset_UTF16(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	// 第二行中 Ċ(U+010A) 的低位字节为 0x0A，并且最后一行还没有写完
+	content := []byte{'a', 0, '\n', 0, 0x0A, 0x01, 'b', 0}
+	if err := ioutil.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	offset, err := tailOffset(name, int64(len(content)), encodings[EncodingUTF16LE])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 4 {
+		t.Fatalf("expect=4 actual=%d", offset)
+	}
+}
+
+func TestParseLineTime(t *testing.T) {
+	expect := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	ts, ok := parseLineTime([]byte("2022-05-01 10:00:00 INFO start"), nil, defaultStartLayout, time.UTC)
+	if !ok || !ts.Equal(expect) {
+		t.Fatalf("unexpect time : %v %v", ts, ok)
+	}
+
+	pattern := regexp.MustCompile(`\[(.+?)\]`)
+	ts, ok = parseLineTime([]byte("INFO [2022-05-01T10:00:00Z] start"), pattern, time.RFC3339, time.UTC)
+	if !ok || !ts.Equal(expect) {
+		t.Fatalf("unexpect time : %v %v", ts, ok)
+	}
+
+	// 行首时间的长度与时间格式不同
+	tests := []struct {
+		line   string
+		layout string
+		expect time.Time
+	}{
+		{"2022-05-01T10:00:00Z INFO start", time.RFC3339, expect},
+		{"2022-05-01T10:00:00Z", time.RFC3339, expect},
+		{"2022-05-01T10:00:00.123456Z INFO start", time.RFC3339Nano, expect.Add(123456 * time.Microsecond)},
+		{"2022-05-01 10:00:00.250 INFO start", defaultStartLayout, expect.Add(250 * time.Millisecond)},
+		{"May  1 10:00:00 host sshd[1]: start", time.Stamp, time.Date(0, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{"September 1 2022 10:00:00 start", "January 2 2006 15:04:05", time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)},
+		{"Wednesday, 01-Jun-22 10:00:00 UTC start", time.RFC850, time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)},
+		{"2022-05-01 10:00:00[main] start", defaultStartLayout, expect},
+	}
+	for _, tt := range tests {
+		ts, ok := parseLineTime([]byte(tt.line), nil, tt.layout, time.UTC)
+		if !ok || !ts.Equal(tt.expect) {
+			t.Fatalf("%s expect=%v, actual=%v %v", tt.line, tt.expect, ts, ok)
+		}
+	}
+
+	if _, ok := parseLineTime([]byte("\tat com.example.Main"), nil, defaultStartLayout, time.UTC); ok {
+		t.Fatal("line without time should not be parsed")
+	}
+}
+
+func TestTimestampOffset(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	base := time.Date(2022, 5, 1, 0, 0, 0, 0, time.Local)
+
+	// 文件远大于 timestampScanSize，并且夹杂着无法解析出时间的异常堆栈
+	var (
+		buf     strings.Builder
+		offsets []int64
+	)
+	for i := 0; i < 20000; i++ {
+		offsets = append(offsets, int64(buf.Len()))
+		buf.WriteString(base.Add(time.Duration(i) * time.Second).Format(defaultStartLayout))
+		buf.WriteString(" line=" + strconv.Itoa(i) + "\n")
+		if i%7 == 0 {
+			buf.WriteString("\tat com.example.Main\n")
+		}
+	}
+	if err := ioutil.WriteFile(name, []byte(buf.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		start  time.Time
+		expect int64
+	}{
+		{base.Add(-time.Hour), 0},
+		{base, 0},
+		{base.Add(7 * time.Second), offsets[7]},
+		{base.Add(12345 * time.Second), offsets[12345]},
+		{base.Add(12345*time.Second + time.Millisecond), offsets[12346]},
+		{base.Add(19999 * time.Second), offsets[19999]},
+		{base.Add(20000 * time.Second), info.Size()},
+	}
+	for i, tt := range tests {
+		beater := newTestHarvester(t, Config{
+			Path:     name,
+			MetaPath: filepath.Join(dir, "meta"),
+			Start: StartConfig{
+				Position: StartTimestamp,
+				Time:     tt.start,
+			},
+		}).(*harvester)
+		offset, err := beater.timestampOffset(fileInfo{FileInfo: info, path: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != tt.expect {
+			t.Errorf("case %d expect=%d actual=%d", i, tt.expect, offset)
+		}
+	}
+}
+
+func TestHarvester_StartPosition(t *testing.T) {
+	dir := t.TempDir()
+	writeLines(t, filepath.Join(dir, "end.log"), "end_line=1")
+	writeLines(t, filepath.Join(dir, "ts.log"),
+		"2022-05-01 09:00:00 old_line=1",
+		"2022-05-01 09:59:59 old_line=2",
+		"\tat com.example.Main",
+		"2022-05-01 10:00:00 new_line=1",
+		"2022-05-01 10:00:01 new_line=2",
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sink := &mockSink{}
+	beater := newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, "ts\\.log$"),
+		MetaPath: filepath.Join(dir, "ts.meta"),
+		Start: StartConfig{
+			Position: StartTimestamp,
+			Time:     time.Date(2022, 5, 1, 10, 0, 0, 0, time.Local),
+		},
+	})
+	beater.RegisterSink(sink)
+	beater.Run(ctx)
+	sink.waitFor(t, 2)
+	time.Sleep(200 * time.Millisecond)
+	if msgs := sink.messages(); len(msgs) != 2 || msgs[0] != "2022-05-01 10:00:00 new_line=1" {
+		t.Fatalf("should start from the first line after start time, actual=%v", msgs)
+	}
+
+	sink = &mockSink{}
+	beater = newTestHarvester(t, Config{
+		Path:     filepath.Join(dir, "end.*\\.log$"),
+		MetaPath: filepath.Join(dir, "end.meta"),
+		Start: StartConfig{
+			Position: StartEnd,
+		},
+	})
+	beater.RegisterSink(sink)
+	beater.Run(ctx)
+	time.Sleep(200 * time.Millisecond)
+	writeLines(t, filepath.Join(dir, "end.log"), "end_line=2")
+	// 启动之后新创建的文件需要从文件头开始采集
+	writeLines(t, filepath.Join(dir, "end-new.log"), "new_line=1")
+	sink.waitFor(t, 2)
+	time.Sleep(200 * time.Millisecond)
+
+	actual := sink.messages()
+	sort.Strings(actual)
+	if len(actual) != 2 || actual[0] != "end_line=2" || actual[1] != "new_line=1" {
+		t.Fatalf("should only harvest lines written after start, actual=%v", actual)
+	}
+}
@@ -0,0 +1,317 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TimestampUnix 秒级的时间戳，支持小数
+	TimestampUnix = "UNIX"
+	// TimestampUnixMs 毫秒级的时间戳
+	TimestampUnixMs = "UNIX_MS"
+)
+
+var (
+	// ErrorTimestampLayout 不支持的时间格式
+	ErrorTimestampLayout error = errors.New("invalid timestamp layout")
+	// ErrorTimestampZone 不支持的时区
+	ErrorTimestampZone error = errors.New("invalid timestamp timezone")
+
+	// namedLayouts 可以直接使用名称的 Go 时间格式
+	namedLayouts = map[string]string{
+		"ANSIC":       time.ANSIC,
+		"UnixDate":    time.UnixDate,
+		"RFC822":      time.RFC822,
+		"RFC822Z":     time.RFC822Z,
+		"RFC1123":     time.RFC1123,
+		"RFC1123Z":    time.RFC1123Z,
+		"RFC3339":     time.RFC3339,
+		"RFC3339Nano": time.RFC3339Nano,
+	}
+
+	// strftimeDirectives strftime 格式中的指令对应的 Go 时间格式
+	strftimeDirectives = map[byte]string{
+		'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'j': "002",
+		'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+		'f': "000000", 'L': "000",
+		'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+		'z': "-0700", 'Z': "MST",
+		'F': "2006-01-02", 'T': "15:04:05", 'D': "01/02/06",
+		'%': "%",
+	}
+
+	// fixedZone +08:00 或者 -0700 格式的时区
+	fixedZone = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})$`)
+)
+
+// timestampProcessor 解析事件发生的时间并设置到 Event.Timestamp，按照顺序使用第一个解析成功的格式
+// 解析失败时不会丢弃事件，保留 Event.Timestamp 为零值并标记 FlagTimestampError
+//
+//   - layouts：时间格式列表，[]string，支持 Go 的时间格式（例如 2006-01-02 15:04:05.000 或者 RFC3339 等名称）、
+//     strftime 格式（包含 %，例如 %Y-%m-%d %H:%M:%S）以及 UNIX、UNIX_MS 时间戳
+//   - field：从 Event.Fields 中的哪个字段解析，字段可以为字符串、数字时间戳或者 time.Time，为空时从 Event.Content 中解析
+//   - pattern：从 Event.Content 中提取时间的正则，有子匹配时使用第一个子匹配，为空时解析行首的时间（时间戳为行首的数字）
+//   - timezone：时间中不包含时区时使用的时区，例如 Asia/Shanghai、UTC、+08:00，为空时使用本地时区
+type timestampProcessor struct {
+	layouts []timestampLayout
+	field   string
+	pattern *regexp.Regexp
+	loc     *time.Location
+}
+
+// timestampLayout 一个时间格式
+type timestampLayout struct {
+	// layout Go 的时间格式，时间戳为空
+	layout string
+	// unit 时间戳的单位
+	unit time.Duration
+}
+
+func newTimestampProcessor(options map[string]interface{}) (Processor, error) {
+	layouts, err := optionStrings(options, "layouts")
+	if err != nil {
+		return nil, err
+	}
+	p := &timestampProcessor{}
+	for i := range layouts {
+		layout, err := parseTimestampLayout(layouts[i])
+		if err != nil {
+			return nil, err
+		}
+		p.layouts = append(p.layouts, layout)
+	}
+	if p.field, err = optionString(options, "field"); err != nil {
+		return nil, err
+	}
+	pattern, err := optionString(options, "pattern")
+	if err != nil {
+		return nil, err
+	}
+	if pattern != "" {
+		if p.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+	}
+	timezone, err := optionString(options, "timezone")
+	if err != nil {
+		return nil, err
+	}
+	if p.loc, err = parseTimezone(timezone); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseTimestampLayout 解析时间格式
+//
+//	@param layout
+//	@return timestampLayout
+//	@return error
+func parseTimestampLayout(layout string) (timestampLayout, error) {
+	switch {
+	case layout == "":
+		return timestampLayout{}, ErrorTimestampLayout
+	case layout == TimestampUnix:
+		return timestampLayout{unit: time.Second}, nil
+	case layout == TimestampUnixMs:
+		return timestampLayout{unit: time.Millisecond}, nil
+	case namedLayouts[layout] != "":
+		return timestampLayout{layout: namedLayouts[layout]}, nil
+	case strings.Contains(layout, "%"):
+		goLayout, err := strftimeToLayout(layout)
+		if err != nil {
+			return timestampLayout{}, err
+		}
+		return timestampLayout{layout: goLayout}, nil
+	default:
+		return timestampLayout{layout: layout}, nil
+	}
+}
+
+// strftimeToLayout 将 strftime 格式转换为 Go 的时间格式
+func strftimeToLayout(format string) (string, error) {
+	var builder strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			builder.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", ErrorTimestampLayout
+		}
+		directive, ok := strftimeDirectives[format[i]]
+		if !ok {
+			return "", ErrorTimestampLayout
+		}
+		builder.WriteString(directive)
+	}
+	return builder.String(), nil
+}
+
+// parseTimezone 解析时区，为空时使用本地时区
+func parseTimezone(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.Local, nil
+	}
+	if match := fixedZone.FindStringSubmatch(timezone); match != nil {
+		hour, _ := strconv.Atoi(match[2])
+		minute, _ := strconv.Atoi(match[3])
+		offset := hour*3600 + minute*60
+		if match[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(timezone, offset), nil
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, ErrorTimestampZone
+	}
+	return loc, nil
+}
+
+// Process
+func (p *timestampProcessor) Process(event *Event) ([]*Event, error) {
+	ts, ok := p.parse(event)
+	if !ok {
+		event.AddFlag(FlagTimestampError)
+		return []*Event{event}, nil
+	}
+	event.Timestamp = ts
+	return []*Event{event}, nil
+}
+
+// parse 按照顺序尝试所有的时间格式
+func (p *timestampProcessor) parse(event *Event) (time.Time, bool) {
+	if p.field != "" {
+		val, ok := event.Fields[p.field]
+		if !ok {
+			return time.Time{}, false
+		}
+		return p.parseValue(val)
+	}
+
+	line := event.Content
+	if p.pattern != nil {
+		match := p.pattern.FindSubmatch(line)
+		switch {
+		case len(match) > 1:
+			line = match[1]
+		case len(match) == 1:
+			line = match[0]
+		default:
+			return time.Time{}, false
+		}
+		return p.parseValue(string(line))
+	}
+	for i := range p.layouts {
+		if ts, ok := p.layouts[i].parsePrefix(line, p.loc); ok {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseValue 解析字段中的时间
+func (p *timestampProcessor) parseValue(val interface{}) (time.Time, bool) {
+	switch v := val.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for i := range p.layouts {
+			if ts, err := p.layouts[i].parse(v, p.loc); err == nil {
+				return ts, true
+			}
+		}
+	case int64, int, float64:
+		for i := range p.layouts {
+			if p.layouts[i].unit > 0 {
+				return p.layouts[i].epoch(v), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// parsePrefix 解析行首的时间，时间戳为行首的数字
+func (l timestampLayout) parsePrefix(line []byte, loc *time.Location) (time.Time, bool) {
+	if l.unit == 0 {
+		return parseTimePrefix(line, l.layout, loc)
+	}
+	end := 0
+	for end < len(line) && (line[end] == '.' || (line[end] >= '0' && line[end] <= '9')) {
+		end++
+	}
+	ts, err := l.parse(string(line[:end]), loc)
+	return ts, err == nil
+}
+
+// parse 按照时间格式解析，不包含时区的时间按照 loc 解析
+func (l timestampLayout) parse(value string, loc *time.Location) (time.Time, error) {
+	if l.unit == 0 {
+		return time.ParseInLocation(l.layout, value, loc)
+	}
+	// 按照十进制的字符串解析小数部分，避免浮点数的精度损失
+	integer, fraction := value, ""
+	if idx := strings.IndexByte(value, '.'); idx >= 0 {
+		integer, fraction = value[:idx], value[idx+1:]
+	}
+	v, err := strconv.ParseInt(integer, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ts := l.epoch(v)
+	if fraction != "" {
+		if len(fraction) > 9 {
+			fraction = fraction[:9]
+		}
+		nanos, err := strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		ts = ts.Add(time.Duration(nanos) * l.unit / time.Second)
+	}
+	return ts.In(loc), nil
+}
+
+// epoch 将时间戳转换为时间
+func (l timestampLayout) epoch(val interface{}) time.Time {
+	switch v := val.(type) {
+	case int64:
+		return time.Unix(0, v*int64(l.unit))
+	case int:
+		return time.Unix(0, int64(v)*int64(l.unit))
+	case float64:
+		// 浮点数只保留到微秒
+		return time.Unix(0, int64(math.Round(v*float64(l.unit/time.Microsecond)))*int64(time.Microsecond))
+	default:
+		return time.Time{}
+	}
+}
@@ -0,0 +1,138 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimestampProcessor(t *testing.T) {
+	shanghai := time.FixedZone("+08:00", 8*3600)
+	expect := time.Date(2022, 5, 1, 10, 0, 0, 123000000, shanghai)
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		event   *Event
+	}{
+		{
+			name:    "go layout prefix with timezone",
+			options: map[string]interface{}{"layouts": []string{"2006-01-02 15:04:05.000"}, "timezone": "+08:00"},
+			event:   &Event{Content: []byte("2022-05-01 10:00:00.123 [INFO] started")},
+		},
+		{
+			name:    "strftime layouts tried in order",
+			options: map[string]interface{}{"layouts": []string{"RFC3339", "%d/%b/%Y:%H:%M:%S.%L"}, "timezone": "+0800"},
+			event:   &Event{Content: []byte("01/May/2022:10:00:00.123 GET /index")},
+		},
+		{
+			name:    "pattern",
+			options: map[string]interface{}{"layouts": "RFC3339Nano", "pattern": `ts=(\S+)`},
+			event:   &Event{Content: []byte("level=info ts=2022-05-01T10:00:00.123+08:00 msg=ok")},
+		},
+		{
+			name:    "epoch millis prefix",
+			options: map[string]interface{}{"layouts": []string{TimestampUnixMs}},
+			event:   &Event{Content: []byte("1651370400123 started")},
+		},
+		{
+			name:    "epoch seconds field",
+			options: map[string]interface{}{"layouts": []string{TimestampUnix}, "field": "ts"},
+			event:   &Event{Fields: map[string]interface{}{"ts": 1651370400.123}},
+		},
+		{
+			name:    "time field",
+			options: map[string]interface{}{"layouts": []string{"RFC3339"}, "field": FieldTime},
+			event:   &Event{Fields: map[string]interface{}{FieldTime: expect}},
+		},
+		{
+			name:    "string field",
+			options: map[string]interface{}{"layouts": []string{"UNIX", "2006-01-02 15:04:05.000"}, "field": "ts", "timezone": "Asia/Shanghai"},
+			event:   &Event{Fields: map[string]interface{}{"ts": "2022-05-01 10:00:00.123"}},
+		},
+	}
+	for _, tt := range tests {
+		processor, err := newTimestampProcessor(tt.options)
+		if err != nil {
+			if errors.Is(err, ErrorTimestampZone) {
+				t.Logf("%s skipped : tzdata not found", tt.name)
+				continue
+			}
+			t.Fatalf("%s : %v", tt.name, err)
+		}
+		if _, err := processor.Process(tt.event); err != nil {
+			t.Fatalf("%s : %v", tt.name, err)
+		}
+		if !tt.event.Timestamp.Equal(expect) || tt.event.HasFlag(FlagTimestampError) {
+			t.Fatalf("%s expect=%v, actual=%v", tt.name, expect, tt.event.Timestamp)
+		}
+	}
+
+	// 行首时间的长度与时间格式不同
+	utc := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	prefixes := []struct {
+		layouts []string
+		line    string
+		expect  time.Time
+	}{
+		{[]string{"RFC3339"}, "2024-01-02T03:04:05Z INFO hello", utc},
+		{[]string{"RFC3339"}, "2024-01-02T03:04:05Z", utc},
+		{[]string{"RFC3339Nano"}, "2024-01-02T03:04:05.5Z INFO hello", utc.Add(500 * time.Millisecond)},
+		{[]string{"2006-01-02 15:04:05"}, "2024-01-02 03:04:05.123456 INFO hello", utc.Add(123456 * time.Microsecond)},
+		{[]string{"%B %d %Y %H:%M:%S"}, "September 02 2024 03:04:05 hello", time.Date(2024, 9, 2, 3, 4, 5, 0, time.UTC)},
+		{[]string{"%A %d %B %Y %H:%M:%S %Z"}, "Tuesday 02 January 2024 03:04:05 UTC hello", utc},
+		{[]string{"Jan _2 2006 15:04:05"}, "Jan  2 2024 03:04:05 hello", utc},
+	}
+	for _, tt := range prefixes {
+		processor, err := newTimestampProcessor(map[string]interface{}{"layouts": tt.layouts, "timezone": "UTC"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		event := &Event{Content: []byte(tt.line)}
+		if _, err := processor.Process(event); err != nil || !event.Timestamp.Equal(tt.expect) || event.HasFlag(FlagTimestampError) {
+			t.Fatalf("%s expect=%v, actual=%v %v", tt.line, tt.expect, event.Timestamp, event.Flags)
+		}
+	}
+
+	// 解析失败时保留事件并标记
+	processor, err := newTimestampProcessor(map[string]interface{}{"layouts": []string{"2006-01-02"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &Event{Content: []byte("no time")}
+	if events, err := processor.Process(event); err != nil || len(events) != 1 || !event.HasFlag(FlagTimestampError) || !event.Timestamp.IsZero() {
+		t.Fatalf("unexpect result : %v, %+v", err, event)
+	}
+
+	for _, options := range []map[string]interface{}{
+		{"layouts": []string{"%Q"}},
+		{"layouts": []string{""}},
+		{"layouts": []string{"UNIX"}, "timezone": "Mars/Olympus"},
+	} {
+		if _, err := newTimestampProcessor(options); err == nil {
+			t.Fatalf("%v expect error", options)
+		}
+	}
+}
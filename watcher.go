@@ -0,0 +1,86 @@
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// WatchAuto 优先使用操作系统提供的文件事件通知机制，不支持时退化为轮询
+	WatchAuto = "auto"
+	// WatchInotify 使用 inotify 感知文件变化，仅支持 Linux，初始化失败时退化为轮询
+	WatchInotify = "inotify"
+	// WatchPoll 定时轮询感知文件变化
+	WatchPoll = "poll"
+
+	// pollScanInterval 轮询模式下扫描待处理文件列表的间隔
+	pollScanInterval = 5 * time.Second
+	// pollReadInterval 轮询模式下读取文件新数据的间隔
+	pollReadInterval = 50 * time.Millisecond
+	// watchScanInterval 事件通知模式下兜底扫描待处理文件列表的间隔
+	watchScanInterval = 30 * time.Second
+	// watchReadInterval 事件通知模式下兜底读取文件新数据的间隔
+	watchReadInterval = time.Second
+)
+
+var (
+	// ErrorWatchUnsupported 当前操作系统不支持文件事件通知
+	ErrorWatchUnsupported error = errors.New("file watch not supported on this platform")
+
+	// ErrorWatchMode 不支持的 Config.WatchMode 配置
+	ErrorWatchMode error = errors.New("watch mode must be auto, inotify or poll")
+)
+
+// watchOp 文件变化的类型
+type watchOp int
+
+const (
+	// watchCreate 目录下有文件被创建或者被移入
+	watchCreate watchOp = iota
+	// watchWrite 文件被写入
+	watchWrite
+	// watchRemove 文件被删除或者被移出
+	watchRemove
+	// watchOverflow 事件队列溢出，有事件被丢弃
+	watchOverflow
+)
+
+// watchEvent 文件变化的事件
+type watchEvent struct {
+	// Path 发生变化的文件路径
+	Path string
+	// Op 变化的类型
+	Op watchOp
+}
+
+// watcher 监听目录下文件的变化
+type watcher interface {
+	// Add 监听目录，重复添加同一个目录不会产生任何影响
+	Add(dir string) error
+	// Events 文件变化的事件，watcher 被关闭后该 channel 会被关闭
+	Events() <-chan watchEvent
+	// Close
+	Close() error
+}
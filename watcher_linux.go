@@ -0,0 +1,201 @@
+//go:build linux
+
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// inotifyMask 关注的 inotify 事件
+	inotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+		unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+	// inotifyBufferSize 单次读取 inotify 事件的缓冲区大小
+	inotifyBufferSize = (unix.SizeofInotifyEvent + unix.NAME_MAX + 1) * 128
+)
+
+// inotifyWatcher 基于 inotify 的 watcher 实现
+type inotifyWatcher struct {
+	lock sync.Mutex
+	fd   int
+	// pipe 用于在 Close 时唤醒阻塞在 poll 上的读取协程
+	pipe [2]int
+	// watches wd -> 目录
+	watches map[int]string
+	// dirs 目录 -> wd
+	dirs map[string]int
+
+	events  chan watchEvent
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// newWatcher 创建一个 inotify watcher
+func newWatcher() (watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		fd:      fd,
+		watches: make(map[int]string),
+		dirs:    make(map[string]int),
+		events:  make(chan watchEvent, 1024),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := unix.Pipe2(w.pipe[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	go w.readEvents()
+	return w, nil
+}
+
+// Add
+func (w *inotifyWatcher) Add(dir string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, ok := w.dirs[dir]; ok {
+		return nil
+	}
+	wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+	w.watches[wd] = dir
+	w.dirs[dir] = wd
+	return nil
+}
+
+// Events
+func (w *inotifyWatcher) Events() <-chan watchEvent {
+	return w.events
+}
+
+// Close
+func (w *inotifyWatcher) Close() error {
+	w.once.Do(func() {
+		close(w.closing)
+		_, _ = unix.Write(w.pipe[1], []byte{0})
+		<-w.done
+		unix.Close(w.pipe[0])
+		unix.Close(w.pipe[1])
+		unix.Close(w.fd)
+	})
+	return nil
+}
+
+// readEvents 读取 inotify 事件，直到 watcher 被关闭
+func (w *inotifyWatcher) readEvents() {
+	defer close(w.done)
+	defer close(w.events)
+
+	buf := make([]byte, inotifyBufferSize)
+	for {
+		fds := []unix.PollFd{
+			{Fd: int32(w.fd), Events: unix.POLLIN},
+			{Fd: int32(w.pipe[0]), Events: unix.POLLIN},
+		}
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return
+		}
+		if fds[1].Revents != 0 {
+			return
+		}
+
+		n, err := unix.Read(w.fd, buf)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[nameStart:nameStart+int(raw.Len)], "\x00"))
+			offset = nameStart + int(raw.Len)
+
+			event, ok := w.convert(int(raw.Wd), raw.Mask, name)
+			if !ok {
+				continue
+			}
+			select {
+			case w.events <- event:
+			case <-w.closing:
+				return
+			}
+		}
+	}
+}
+
+// convert 将 inotify 事件转换为 watchEvent
+func (w *inotifyWatcher) convert(wd int, mask uint32, name string) (watchEvent, bool) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		return watchEvent{Op: watchOverflow}, true
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	dir, ok := w.watches[wd]
+	if !ok {
+		return watchEvent{}, false
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		// 目录被删除或者被移走，inotify 会自动移除对应的 watch
+		delete(w.watches, wd)
+		delete(w.dirs, dir)
+		return watchEvent{Path: dir, Op: watchRemove}, true
+	}
+
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+	switch {
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		return watchEvent{Path: path, Op: watchCreate}, true
+	case mask&(unix.IN_DELETE|unix.IN_MOVED_FROM|unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+		return watchEvent{Path: path, Op: watchRemove}, true
+	case mask&unix.IN_MODIFY != 0:
+		return watchEvent{Path: path, Op: watchWrite}, true
+	}
+	return watchEvent{}, false
+}
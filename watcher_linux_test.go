@@ -0,0 +1,102 @@
+//go:build linux
+
+// MIT License
+
+// Copyright (c) 2022 liaochuntao
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filebeat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInotifyWatcher(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "app.log")
+	writeLines(t, name, "app_line=1")
+	expectWatchEvent(t, w, watchEvent{Path: name, Op: watchCreate})
+	expectWatchEvent(t, w, watchEvent{Path: name, Op: watchWrite})
+
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, w, watchEvent{Path: name, Op: watchRemove})
+}
+
+func TestHarvester_WatchInotify(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+
+	sink := &mockSink{}
+	beater := newTestHarvester(t, Config{
+		Path:      filepath.Join(dir, ".*\\.log$"),
+		MetaPath:  filepath.Join(dir, "meta"),
+		WatchMode: WatchInotify,
+	})
+	beater.RegisterSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beater.Run(ctx)
+	if beater.(*harvester).watcher == nil {
+		t.Fatal("inotify watcher should be enabled")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// 新文件的创建以及写入都由事件驱动，不需要等待兜底的扫描以及读取间隔
+	writeLines(t, name, "app_line=1")
+	start := time.Now()
+	sink.waitFor(t, 1)
+	writeLines(t, name, "app_line=2")
+	sink.waitFor(t, 2)
+	if cost := time.Since(start); cost >= watchReadInterval {
+		t.Fatalf("events should be delivered by inotify, cost=%s", cost)
+	}
+}
+
+func expectWatchEvent(t *testing.T, w watcher, expect watchEvent) {
+	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case event := <-w.Events():
+			if event == expect {
+				return
+			}
+		case <-timer.C:
+			t.Fatalf("wait for watch event %+v timeout", expect)
+		}
+	}
+}